@@ -0,0 +1,94 @@
+package objtree
+
+import (
+	"context"
+	"github.com/godbus/dbus"
+)
+
+// CallInfo describes a method call. Args holds the decoded arguments
+// of the method, including any dbus.Sender. Sender is empty for calls
+// made directly with Call rather than received from the bus. Context
+// is passed to any context.Context argument of the method the caller
+// left nil; an interceptor may replace it. Trace holds the trace
+// context the caller sent, if any; see Tracing.
+type CallInfo struct {
+	Context   context.Context
+	Trace     map[string]string
+	Sender    string
+	Path      dbus.ObjectPath
+	Interface string
+	Member    string
+	Args      []interface{}
+
+	method *Method
+}
+
+// An Authorizer decides whether a method call received from the bus
+// may proceed. A non-nil error denies the call and is returned to the
+// caller; errors other than a dbus.Error are sent as
+// org.freedesktop.DBus.Error.AccessDenied.
+type Authorizer interface {
+	Authorize(call *CallInfo) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface.
+type AuthorizerFunc func(call *CallInfo) error
+
+func (fn AuthorizerFunc) Authorize(call *CallInfo) error {
+	return fn(call)
+}
+
+// SetAuthorizer installs a to check every method call the manager
+// receives before it is made. Calls made directly through Object.Call
+// or BusManager.Call are not checked. Passing nil allows every call.
+func (mgr *BusManager) SetAuthorizer(a Authorizer) {
+	mgr.state.mu.Lock()
+	mgr.state.authorizer = a
+	mgr.state.mu.Unlock()
+}
+
+func (mgr *BusManager) getAuthorizer() Authorizer {
+	mgr.state.mu.Lock()
+	defer mgr.state.mu.Unlock()
+	return mgr.state.authorizer
+}
+
+// authorize checks a call received from the bus against the owner of
+// the object and the manager's Authorizer.
+func (method *Method) authorize(sender string, args []interface{}) error {
+	o := method.object
+	if o == nil {
+		return nil
+	}
+	owner := o.getOwner()
+	if owner.mode == OwnerExclusive && sender != owner.name &&
+		method.iface != fdtIntrospectable && method.iface != fdtPeer {
+		return accessDenied("Only " + owner.name +
+			" may call methods on this object")
+	}
+	bus := o.getBus()
+	if bus == nil {
+		return nil
+	}
+	a := bus.getAuthorizer()
+	if a == nil {
+		return nil
+	}
+	err := a.Authorize(&CallInfo{
+		Sender:    sender,
+		Path:      method.path(),
+		Interface: method.iface,
+		Member:    method.name,
+		Args:      args,
+		method:    method,
+	})
+	switch err.(type) {
+	case nil, dbus.Error, *dbus.Error:
+		return err
+	}
+	return accessDenied(err.Error())
+}
+
+func accessDenied(text string) *dbus.Error {
+	return dbus.NewError(fdtAccessDenied, []interface{}{text})
+}
@@ -0,0 +1,106 @@
+package objtree
+
+import (
+	"errors"
+	"github.com/godbus/dbus"
+	"github.com/jsouthworth/objtree/loopback"
+	"testing"
+)
+
+func callTest(conn *dbus.Conn, path dbus.ObjectPath) error {
+	return conn.Object("com.github.jsouthworth.objtree.Test", path).
+		Call("com.github.jsouthworth.objtree.Test.CallMe", 0).Err
+}
+
+func expectAccessDenied(t *testing.T, err error) {
+	t.Helper()
+	dbusErr, ok := err.(dbus.Error)
+	if !ok || dbusErr.Name != fdtAccessDenied {
+		t.Fatal("expected AccessDenied got:", err)
+	}
+}
+
+func TestSetAuthorizer(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	obj := mgr.NewObject("/foo", &testObj{})
+	obj.Implements("com.github.jsouthworth.objtree.Test", (*testIface)(nil))
+	client := newLoopbackClient(t, bus)
+	var got *CallInfo
+	mgr.SetAuthorizer(AuthorizerFunc(func(call *CallInfo) error {
+		got = call
+		if call.Path == "/foo" {
+			return errors.New("no")
+		}
+		return nil
+	}))
+	expectAccessDenied(t, callTest(client, "/foo"))
+	if got.Sender != client.Names()[0] || got.Path != "/foo" ||
+		got.Interface != "com.github.jsouthworth.objtree.Test" ||
+		got.Member != "CallMe" {
+		t.Fatal("unexpected call info:", got)
+	}
+	mgr.SetAuthorizer(AuthorizerFunc(func(call *CallInfo) error {
+		return dbus.NewError("com.example.Error.Custom", nil)
+	}))
+	err := callTest(client, "/foo")
+	if dbusErr, ok := err.(dbus.Error); !ok ||
+		dbusErr.Name != "com.example.Error.Custom" {
+		t.Fatal("D-Bus errors should be returned as is got:", err)
+	}
+	mgr.SetAuthorizer(nil)
+	if err := callTest(client, "/foo"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	obj := mgr.NewObject("/foo", &testObj{})
+	obj.Implements("com.github.jsouthworth.objtree.Test", (*testIface)(nil))
+	admin := newLoopbackClient(t, bus)
+	defer admin.Close()
+	user := newLoopbackClient(t, bus)
+	bus.SetCredentials(admin.Names()[0], loopback.Credentials{UID: 0})
+	bus.SetCredentials(user.Names()[0],
+		loopback.Credentials{UID: 1000, GIDs: []uint32{1000}})
+	policy := NewPolicy(mgr, Deny,
+		Rule{Interface: fdtIntrospectable, Action: Allow},
+		Rule{UIDs: []uint32{0}, Action: Allow},
+	)
+	defer policy.Close()
+	mgr.SetAuthorizer(policy)
+	if err := callTest(admin, "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	expectAccessDenied(t, callTest(user, "/foo"))
+	err := user.Object("com.github.jsouthworth.objtree.Test", "/foo").
+		Call(fdtIntrospectable+".Introspect", 0).Err
+	if err != nil {
+		t.Fatal("introspection should be allowed:", err)
+	}
+	policy.AddRule(Rule{
+		Interface: "com.github.jsouthworth.objtree.Test",
+		Member:    "CallMe",
+		GIDs:      []uint32{1000},
+		Action:    Allow,
+	})
+	if err := callTest(user, "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	name := user.Names()[0]
+	policy.mu.Lock()
+	_, cached := policy.creds[name]
+	policy.mu.Unlock()
+	if !cached {
+		t.Fatal("credentials should be cached")
+	}
+	user.Close()
+	waitFor(t, func() bool {
+		policy.mu.Lock()
+		defer policy.mu.Unlock()
+		_, cached := policy.creds[name]
+		return !cached
+	})
+}
@@ -2,7 +2,6 @@ package objtree
 
 import (
 	"github.com/godbus/dbus"
-	"strings"
 	"sync"
 	"sync/atomic"
 )
@@ -13,6 +12,8 @@ const (
 	fdtRemoveMatch    = fdtDBusName + ".RemoveMatch"
 	fdtIntrospectable = fdtDBusName + ".Introspectable"
 	fdtPeer           = fdtDBusName + ".Peer"
+	fdtNameHasOwner   = fdtDBusName + ".NameHasOwner"
+	fdtAccessDenied   = fdtDBusName + ".Error.AccessDenied"
 )
 
 // Acts as a root to the object tree
@@ -25,12 +26,20 @@ type BusManager struct {
 func NewAnonymousBusManager(
 	busfn func(dbus.Handler, dbus.SignalHandler) (*dbus.Conn, error),
 ) (*BusManager, error) {
-	state := &mgrState{sigref: make(map[string]uint64)}
+	state := &mgrState{
+		sigref:    make(map[signalKey]uint64),
+		matches:   make(map[string]struct{}),
+		owners:    make(map[string]map[*Object]struct{}),
+		trackers:  make(map[nameTracker]struct{}),
+		dirty:     make(map[string]struct{}),
+		unchecked: make(map[string]struct{}),
+	}
 	handler := &BusManager{
 		Object: newObjectFromImpl("", nil, nil, nil),
 		state:  state,
 	}
-	handler.bus = handler
+	handler.bind(handler, handler.getTree())
+	handler.getTree().bus = handler
 	conn, err := busfn(handler, handler)
 	if err != nil {
 		return nil, err
@@ -96,16 +105,46 @@ func (mgr *BusManager) RequestName(name string) error {
 	return nil
 }
 
+// SetMachineIdFunc overrides the machine id reported by
+// org.freedesktop.DBus.Peer.GetMachineId for objects in this tree.
+// Passing nil restores the default of reading it from the system.
+func (mgr *BusManager) SetMachineIdFunc(fn func() (string, error)) {
+	mgr.state.mu.Lock()
+	mgr.state.machineIdFn = fn
+	mgr.state.mu.Unlock()
+}
+
+func (mgr *BusManager) machineId() (string, error) {
+	mgr.state.mu.Lock()
+	fn := mgr.state.machineIdFn
+	mgr.state.mu.Unlock()
+	if fn == nil {
+		return readMachineId()
+	}
+	return fn()
+}
+
 func (mgr *BusManager) LookupObject(path dbus.ObjectPath) (dbus.ServerObject, bool) {
 	if string(path) == "/" {
 		return mgr, true
 	}
-
-	ps := strings.Split(string(path), "/")
-	if ps[0] == "" {
-		ps = ps[1:]
+	if ValidatePath(path) != nil {
+		return nil, false
 	}
-	return mgr.lookupObjectPath(ps)
+	tree := mgr.rlockTree()
+	obj, ref := mgr.findObjectPath(pathToStringSlice(path))
+	tree.RUnlock()
+	if obj != nil {
+		return obj, true
+	}
+	obj, err := ref.resolve()
+	switch {
+	case err != nil:
+		return failedObject{err}, true
+	case obj == nil:
+		return nil, false
+	}
+	return obj, true
 }
 
 func (mgr *BusManager) Call(
@@ -114,18 +153,26 @@ func (mgr *BusManager) Call(
 	method string,
 	args ...interface{},
 ) ([]interface{}, error) {
+	if string(path) == "/" {
+		return mgr.Object.Call(ifaceName, method, args...)
+	}
 	object, ok := mgr.LookupObject(path)
 	if !ok {
 		return nil, dbus.ErrMsgNoObject
 	}
+	if failed, ok := object.(failedObject); ok {
+		return nil, failed.err
+	}
 	return object.(*Object).Call(ifaceName, method, args...)
 }
 
 func (mgr *BusManager) DeliverSignal(iface, member string, signal *dbus.Signal) {
-	objects := mgr.objects.Load().(map[string]*Object)
-	for _, obj := range objects {
-		obj.DeliverSignal(iface, member, signal)
+	if iface == fdtDBusName && member == "NameOwnerChanged" {
+		mgr.nameOwnerChanged(signal)
 	}
+	eachChild(mgr.getObjects(), func(_ string, obj *Object) {
+		obj.DeliverSignal(iface, member, signal)
+	})
 }
 
 type multiWriterValue struct {
@@ -144,37 +191,99 @@ func (value *multiWriterValue) Update(fn func(interface{}) interface{}) {
 }
 
 type mgrState struct {
-	mu     sync.Mutex
-	sigref map[string]uint64
+	mu          sync.Mutex
+	matches     map[string]struct{}
+	authorizer  Authorizer
+	machineIdFn func() (string, error)
+	logger      atomic.Value
+
+	// refMu guards the references held on match rules and the bus
+	// work left for flush. Unlike mu it is never held while waiting
+	// for the bus, so signal delivery and changes made under the tree
+	// lock may take it.
+	refMu     sync.Mutex
+	sigref    map[signalKey]uint64
+	owners    map[string]map[*Object]struct{}
+	trackers  map[nameTracker]struct{}
+	dirty     map[string]struct{}
+	unchecked map[string]struct{}
+}
+
+// signalKey names a signal listeners are registered for.
+type signalKey struct {
+	iface  string
+	member string
 }
 
-func mkSignalKey(iface, member string) string {
-	return iface + "." + member
+func (k signalKey) rule() string {
+	return "type='signal',interface='" + k.iface +
+		"',member='" + k.member + "'"
 }
 
-func (s *mgrState) AddMatchSignal(conn *dbus.Conn, iface, member string) {
-	// Only register for signal if not already registered
+// AddMatchSignal adds a reference to the match rule for a signal,
+// adding the rule to the bus if it is not there yet. If the bus
+// refuses the rule the reference is not taken and the bus's error is
+// returned.
+func (s *mgrState) AddMatchSignal(
+	conn *dbus.Conn,
+	iface, member string,
+) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	key := mkSignalKey(iface, member)
-	if s.sigref[key] == 0 {
-		conn.BusObject().Call(fdtAddMatch, 0,
-			"type='signal',interface='"+iface+"',member='"+member+"'")
+	key := signalKey{iface, member}
+	s.refMu.Lock()
+	s.sigref[key]++
+	s.refMu.Unlock()
+	if _, ok := s.matches[key.rule()]; ok {
+		return nil
 	}
-	s.sigref[key] = s.sigref[key] + 1
+	if err := s.addMatch(conn, key.rule()); err != nil {
+		s.dropMatchSignal(iface, member)
+		return err
+	}
+	return nil
 }
 
-func (s *mgrState) RemoveMatchSignal(conn *dbus.Conn, iface, member string) {
-	// Only deregister if this is the last request
+// holdMatchSignal is AddMatchSignal for listeners that exist whether
+// or not the bus accepts the rule. The reference is taken right away
+// and the rule is left for flush to add, or for SyncMatches if the bus
+// refuses it.
+func (s *mgrState) holdMatchSignal(iface, member string) {
+	key := signalKey{iface, member}
+	s.refMu.Lock()
+	s.sigref[key]++
+	s.dirty[key.rule()] = struct{}{}
+	s.refMu.Unlock()
+}
+
+// RemoveMatchSignal drops a reference to the match rule for a signal,
+// removing the rule from the bus with the last one. The reference is
+// dropped even if the bus fails to remove the rule; SyncMatches
+// retries the removal.
+func (s *mgrState) RemoveMatchSignal(
+	conn *dbus.Conn,
+	iface, member string,
+) error {
+	s.dropMatchSignal(iface, member)
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	key := mkSignalKey(iface, member)
+	return s.syncMatch(conn, signalKey{iface, member}.rule(),
+		s.wantedMatches())
+}
+
+// dropMatchSignal drops a reference to the match rule for a signal and
+// leaves the removal of the rule to flush.
+func (s *mgrState) dropMatchSignal(iface, member string) {
+	key := signalKey{iface, member}
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
 	if s.sigref[key] == 0 {
 		return
 	}
-	s.sigref[key] = s.sigref[key] - 1
-	if s.sigref[key] == 0 {
-		conn.BusObject().Call(fdtRemoveMatch, 0,
-			"type='signal',interface='"+iface+"',member='"+member+"'")
+	s.sigref[key]--
+	if s.sigref[key] > 0 {
+		return
 	}
+	delete(s.sigref, key)
+	s.dirty[key.rule()] = struct{}{}
 }
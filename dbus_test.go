@@ -2,6 +2,7 @@ package objtree
 
 import (
 	"github.com/godbus/dbus"
+	"github.com/jsouthworth/objtree/loopback"
 	"testing"
 	"time"
 )
@@ -224,7 +225,7 @@ func TestBusManagerDeleteReceiver(t *testing.T) {
 	if obj == nil {
 		t.Fatal("unexpected nil")
 	}
-	err = obj.ReceivesTable("foo", methods)
+	err = obj.ReceivesTable("com.example.Foo", methods)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -232,7 +233,7 @@ func TestBusManagerDeleteReceiver(t *testing.T) {
 	if obj2 == nil {
 		t.Fatal("unexpected nil")
 	}
-	err = obj2.ReceivesTable("foo", methods)
+	err = obj2.ReceivesTable("com.example.Foo", methods)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -241,7 +242,7 @@ func TestBusManagerDeleteReceiver(t *testing.T) {
 	sig := &dbus.Signal{
 		Body: []interface{}{expected},
 	}
-	root.DeliverSignal("foo", "CallMe", sig)
+	root.DeliverSignal("com.example.Foo", "CallMe", sig)
 	got := <-ch
 	if got != expected {
 		t.Fatal("expected:", expected, "got:", got)
@@ -253,6 +254,23 @@ func TestBusManagerDeleteReceiver(t *testing.T) {
 	}
 }
 
+func TestBusManagerReceivesInvalidInterface(t *testing.T) {
+	root, err := NewSessionBusManager("com.github.jsouthworth.objtree.Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	methods := map[string]interface{}{
+		"CallMe": func(ins ...interface{}) {},
+	}
+	obj := root.NewObjectFromTable("/foo/bar/call", methods)
+	if err := obj.ReceivesTable("foo", methods); err == nil {
+		t.Fatal("expected the bus to refuse the interface name")
+	}
+	if _, ok := obj.getListeners()["foo"]; ok {
+		t.Fatal("the listener should not have been added")
+	}
+}
+
 func TestBusManagerDeleteAllReceivers(t *testing.T) {
 	ch := make(chan string)
 	root, err := NewSessionBusManager("com.github.jsouthworth.objtree.Test")
@@ -268,7 +286,7 @@ func TestBusManagerDeleteAllReceivers(t *testing.T) {
 	if obj == nil {
 		t.Fatal("unexpected nil")
 	}
-	err = obj.ReceivesTable("foo", methods)
+	err = obj.ReceivesTable("com.example.Foo", methods)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -276,7 +294,7 @@ func TestBusManagerDeleteAllReceivers(t *testing.T) {
 	if obj2 == nil {
 		t.Fatal("unexpected nil")
 	}
-	err = obj2.ReceivesTable("foo", methods)
+	err = obj2.ReceivesTable("com.example.Foo", methods)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -286,7 +304,7 @@ func TestBusManagerDeleteAllReceivers(t *testing.T) {
 	sig := &dbus.Signal{
 		Body: []interface{}{expected},
 	}
-	root.DeliverSignal("foo", "CallMe", sig)
+	root.DeliverSignal("com.example.Foo", "CallMe", sig)
 
 	select {
 	case <-ch:
@@ -299,3 +317,172 @@ func TestBusManagerDeleteAllReceivers(t *testing.T) {
 	case <-time.After(time.Second):
 	}
 }
+
+func newLoopbackBusManager(t *testing.T) (*loopback.Bus, *BusManager) {
+	bus := loopback.New()
+	mgr, err := NewBusManager(bus.DialHandler,
+		"com.github.jsouthworth.objtree.Test")
+	if err != nil {
+		bus.Close()
+		t.Fatal(err)
+	}
+	return bus, mgr
+}
+
+func newLoopbackClient(t *testing.T, bus *loopback.Bus) *dbus.Conn {
+	conn, err := bus.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Auth(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Hello(); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestLoopbackBusManagerCall(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	obj := mgr.NewObject("/foo/bar", &testObj{})
+	err := obj.Implements("com.github.jsouthworth.objtree.Test",
+		(*testIface)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newLoopbackClient(t, bus)
+	var out string
+	err = client.Object("com.github.jsouthworth.objtree.Test", "/foo/bar").
+		Call("com.github.jsouthworth.objtree.Test.CallMe", 0).Store(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "hello, world" {
+		t.Fatal("got:", out, "expected:", "hello, world")
+	}
+}
+
+func TestLoopbackBusManagerCallNonExistent(t *testing.T) {
+	bus, _ := newLoopbackBusManager(t)
+	defer bus.Close()
+	client := newLoopbackClient(t, bus)
+	err := client.Object("com.github.jsouthworth.objtree.Test", "/foo/baz").
+		Call("com.github.jsouthworth.objtree.Test.CallMe", 0).Err
+	dbusErr, ok := err.(dbus.Error)
+	if !ok {
+		t.Fatal("expected dbus.Error got:", err)
+	}
+	if dbusErr.Name != "org.freedesktop.DBus.Error.NoSuchObject" {
+		t.Fatal("unexpected error:", dbusErr.Name)
+	}
+}
+
+func TestLoopbackBusManagerIntrospect(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	obj := mgr.NewObject("/foo/bar", &testObj{})
+	err := obj.Implements("com.github.jsouthworth.objtree.Test",
+		(*testIface)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newLoopbackClient(t, bus)
+	var intro string
+	err = client.Object("com.github.jsouthworth.objtree.Test", "/foo").
+		Call(fdtIntrospectable+".Introspect", 0).Store(&intro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := decodeIntrospection(intro)
+	if len(node.Children) != 1 || node.Children[0].Name != "bar" {
+		t.Fatal("unexpected introspection:", intro)
+	}
+	err = client.Object("com.github.jsouthworth.objtree.Test", "/foo/bar").
+		Call(fdtIntrospectable+".Introspect", 0).Store(&intro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node = decodeIntrospection(intro)
+	found := false
+	for _, iface := range node.Interfaces {
+		if iface.Name == "com.github.jsouthworth.objtree.Test" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("interface missing from introspection:", intro)
+	}
+}
+
+func TestLoopbackBusManagerReceives(t *testing.T) {
+	ch := make(chan string)
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	methods := map[string]interface{}{
+		"CallMe": func(in string) {
+			ch <- in
+		},
+	}
+	obj := mgr.NewObjectFromTable("/foo/bar", methods)
+	err := obj.ReceivesTable("com.github.jsouthworth.objtree.Test", methods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newLoopbackClient(t, bus)
+	expected := "hello, world"
+	err = client.Emit("/baz", "com.github.jsouthworth.objtree.Test.CallMe",
+		expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-ch:
+		if got != expected {
+			t.Fatal("expected:", expected, "got:", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for signal")
+	}
+	mgr.DeleteObject("/foo/bar")
+	err = client.Emit("/baz", "com.github.jsouthworth.objtree.Test.CallMe",
+		expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ch:
+		t.Fatal("expected timeout to occur")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBusManagerSetMachineIdFunc(t *testing.T) {
+	const expected = "0123456789abcdef0123456789abcdef"
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	mgr.SetMachineIdFunc(func() (string, error) {
+		return expected, nil
+	})
+	mgr.NewObject("/foo/bar", &testObj{})
+	outs, err := mgr.Call("/foo/bar", fdtPeer, "GetMachineId")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outs[0].(string) != expected {
+		t.Fatal("expected:", expected, "got:", outs[0])
+	}
+}
+
+func TestBusManagerObjectManager(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	obj := mgr.NewObject("/foo/bar", &testObj{})
+	if obj.Manager() != mgr || obj.Parent().Manager() != mgr {
+		t.Fatal("expected objects to report their manager")
+	}
+	if obj.Path() != "/foo/bar" {
+		t.Fatal("unexpected path:", obj.Path())
+	}
+}
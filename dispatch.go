@@ -0,0 +1,181 @@
+package objtree
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrQueueFull is returned by calls made through Call on an object
+// whose Queue has no room left. Calls received from the bus are
+// answered with org.freedesktop.DBus.Error.LimitsExceeded instead.
+var ErrQueueFull = errors.New("objtree: dispatch queue full")
+
+// A Queue runs the method calls and signal deliveries of the objects
+// it serializes one at a time, in the order they reach it, so that
+// their implementations need no locking of their own. At most size
+// calls may wait for their turn; further calls are rejected and
+// further signals dropped until there is room.
+//
+// A method running on a Queue must not make calls through Call that
+// need the same Queue, or it will wait for itself forever.
+type Queue struct {
+	size int
+
+	mu       sync.Mutex
+	pending  []func()
+	running  bool
+	rejected uint64
+}
+
+// NewQueue returns a Queue on which up to size calls may wait. A size
+// below one is taken as one.
+func NewQueue(size int) *Queue {
+	if size < 1 {
+		size = 1
+	}
+	return &Queue{size: size}
+}
+
+// Len returns the number of calls waiting on q.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Rejected returns the number of calls and signals q has turned away
+// because it was full.
+func (q *Queue) Rejected() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.rejected
+}
+
+// run calls fn on q's worker and waits for it to return.
+func (q *Queue) run(fn func()) error {
+	done := make(chan struct{})
+	q.mu.Lock()
+	if len(q.pending) >= q.size {
+		q.rejected++
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	q.pending = append(q.pending, func() {
+		defer close(done)
+		fn()
+	})
+	if !q.running {
+		q.running = true
+		go q.work()
+	}
+	q.mu.Unlock()
+	<-done
+	return nil
+}
+
+// work runs the pending calls until there are none left. A worker
+// only exists while q has something to do.
+func (q *Queue) work() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		fn := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		fn()
+	}
+}
+
+// Serialize runs the method calls and signal deliveries of o on q.
+// Several objects may share one Queue. Passing nil stops o from being
+// serialized other than by a Queue set with SerializeSubtree.
+func (o *Object) Serialize(q *Queue) {
+	o.updateInterceptors(func(ic *interceptors) {
+		ic.queue = q
+	})
+}
+
+// SerializeSubtree runs the method calls and signal deliveries of o
+// and of every object below it, including those added later, on the
+// single Queue q. An object's own Queue set with Serialize takes
+// precedence, as does a Queue set on a subtree nearer to the object.
+func (o *Object) SerializeSubtree(q *Queue) {
+	o.updateInterceptors(func(ic *interceptors) {
+		ic.subtreeQueue = q
+	})
+}
+
+// getQueue returns the Queue that serializes the calls made on o, if
+// any.
+func (o *Object) getQueue() *Queue {
+	if ic := o.getInterceptors(); ic != nil && ic.queue != nil {
+		return ic.queue
+	}
+	for node := o; node != nil; node = node.getParent() {
+		if ic := node.getInterceptors(); ic != nil &&
+			ic.subtreeQueue != nil {
+			return ic.subtreeQueue
+		}
+	}
+	return nil
+}
+
+// invoke calls the implementation of method, on the object's Queue if
+// it has one, passing ctx for any context.Context argument left nil.
+// The standard interfaces do not touch the implementation and are
+// never queued.
+func (method *Method) invoke(
+	ctx context.Context,
+	args []interface{},
+) ([]interface{}, error) {
+	args = method.injectContext(ctx, args)
+	var q *Queue
+	if method.object != nil && method.iface != fdtIntrospectable &&
+		method.iface != fdtPeer {
+		q = method.object.getQueue()
+	}
+	if q == nil {
+		return method.callImpl(args)
+	}
+	var (
+		outs []interface{}
+		err  error
+	)
+	if qerr := q.run(func() {
+		outs, err = method.callImpl(args)
+	}); qerr != nil {
+		if method.sender != "" {
+			return nil, limitsExceeded("Too many calls waiting on " +
+				string(method.path()))
+		}
+		return nil, qerr
+	}
+	return outs, err
+}
+
+func (method *Method) injectContext(
+	ctx context.Context,
+	args []interface{},
+) []interface{} {
+	var out []interface{}
+	for i, arg := range args {
+		if arg != nil || i >= method.impl.NumArguments() ||
+			method.impl.ArgumentType(i) != contexttype {
+			continue
+		}
+		if out == nil {
+			out = append([]interface{}(nil), args...)
+		}
+		out[i] = ctx
+	}
+	if out == nil {
+		return args
+	}
+	return out
+}
@@ -0,0 +1,170 @@
+package objtree
+
+import (
+	"github.com/godbus/dbus"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyProbe records the most calls it has seen running at
+// once.
+type concurrencyProbe struct {
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+func (p *concurrencyProbe) enter() {
+	p.mu.Lock()
+	p.running++
+	if p.running > p.max {
+		p.max = p.running
+	}
+	p.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	p.mu.Lock()
+	p.running--
+	p.mu.Unlock()
+}
+
+func (p *concurrencyProbe) maxRunning() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.max
+}
+
+func newProbedObject(parent *Object, path dbus.ObjectPath,
+	p *concurrencyProbe) *Object {
+	table := map[string]interface{}{
+		"Work": func() { p.enter() },
+	}
+	obj := parent.NewObjectFromTable(path, table)
+	obj.ImplementsTable("com.example.Test", table)
+	obj.ReceivesTable("com.example.Test", table)
+	return obj
+}
+
+func callConcurrently(t *testing.T, n int, objs ...*Object) {
+	t.Helper()
+	var wg sync.WaitGroup
+	errs := make(chan error, n*len(objs))
+	for i := 0; i < n; i++ {
+		for _, obj := range objs {
+			wg.Add(1)
+			go func(obj *Object) {
+				defer wg.Done()
+				_, err := obj.Call("com.example.Test", "Work")
+				errs <- err
+			}(obj)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	serial, free := &concurrencyProbe{}, &concurrencyProbe{}
+	obj := newProbedObject(root, "/serial", serial)
+	obj.Serialize(NewQueue(16))
+	other := newProbedObject(root, "/free", free)
+	callConcurrently(t, 8, obj, other)
+	if serial.maxRunning() != 1 {
+		t.Fatal("calls should have been serialized, saw",
+			serial.maxRunning(), "at once")
+	}
+	if free.maxRunning() < 2 {
+		t.Fatal("an unserialized object should run calls concurrently")
+	}
+}
+
+func TestSerializeSignals(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	p := &concurrencyProbe{}
+	obj := newProbedObject(root, "/foo", p)
+	q := NewQueue(16)
+	obj.Serialize(q)
+	for i := 0; i < 8; i++ {
+		root.DeliverSignal("com.example.Test", "Work",
+			&dbus.Signal{Path: "/emitter"})
+	}
+	waitFor(t, func() bool { return q.Len() == 0 })
+	if _, err := obj.Call("com.example.Test", "Work"); err != nil {
+		t.Fatal(err)
+	}
+	if p.maxRunning() != 1 {
+		t.Fatal("signals should have been serialized, saw",
+			p.maxRunning(), "at once")
+	}
+}
+
+func TestSerializeSubtree(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	p := &concurrencyProbe{}
+	group := root.NewObject("/group", &testObj{})
+	group.SerializeSubtree(NewQueue(32))
+	a := newProbedObject(root, "/group/a", p)
+	b := newProbedObject(root, "/group/a/b", p)
+	// replacing the group root keeps its children serialized
+	root.NewObject("/group", &testObj{})
+	callConcurrently(t, 8, a, b)
+	if p.maxRunning() != 1 {
+		t.Fatal("the subtree should share one queue, saw",
+			p.maxRunning(), "at once")
+	}
+	own := &concurrencyProbe{}
+	c := newProbedObject(root, "/group/c", own)
+	c.Serialize(NewQueue(8))
+	callConcurrently(t, 4, a, c)
+	if own.maxRunning() != 1 || p.maxRunning() != 1 {
+		t.Fatal("an object's own queue should serialize its calls")
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	table := map[string]interface{}{
+		"Block": func() {
+			started <- struct{}{}
+			<-unblock
+		},
+	}
+	obj := mgr.NewObjectFromTable("/foo", table)
+	obj.ImplementsTable("com.example.Test", table)
+	q := NewQueue(1)
+	obj.Serialize(q)
+	client := newLoopbackClient(t, bus)
+	remote := client.Object("com.github.jsouthworth.objtree.Test", "/foo")
+	running := remote.Go("com.example.Test.Block", 0, nil)
+	<-started
+	waiting := remote.Go("com.example.Test.Block", 0, nil)
+	waitFor(t, func() bool { return q.Len() == 1 })
+	expectLimitsExceeded(t,
+		remote.Call("com.example.Test.Block", 0).Err)
+	if _, err := obj.Call("com.example.Test", "Block"); err != ErrQueueFull {
+		t.Fatal("expected ErrQueueFull got:", err)
+	}
+	if q.Rejected() != 2 {
+		t.Fatal("expected 2 rejections got:", q.Rejected())
+	}
+	err := client.Object("com.github.jsouthworth.objtree.Test", "/foo").
+		Call(fdtIntrospectable+".Introspect", 0).Err
+	if err != nil {
+		t.Fatal("introspection should not be queued got:", err)
+	}
+	close(unblock)
+	for _, call := range []*dbus.Call{<-running.Done, <-waiting.Done} {
+		if call.Err != nil {
+			t.Fatal(call.Err)
+		}
+	}
+}
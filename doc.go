@@ -0,0 +1,17 @@
+// Package objtree exports a tree of go objects on D-Bus, generating
+// the introspection data for each object from its methods.
+//
+// Objects are placed in the tree by path. NewObject and its variants
+// replace whatever is at the path and do not validate it, which suits
+// paths fixed at compile time. Paths built at run time,
+// for example from user or device names, should be built with JoinPath
+// so that each label is escaped, and the object created with
+// CreateObject, which reports an invalid path or an existing object as
+// an error:
+//
+//	path := objtree.JoinPath("/com/example/users", name)
+//	obj, err := mgr.CreateObject(path, user, objtree.CreateExclusive)
+//	if err != nil {
+//		return err
+//	}
+package objtree
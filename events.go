@@ -0,0 +1,212 @@
+package objtree
+
+import (
+	"github.com/godbus/dbus"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EventKind identifies the kind of change an Event describes.
+type EventKind int
+
+const (
+	// ObjectAdded is sent when an object is created or a placeholder
+	// is promoted to a real object.
+	ObjectAdded EventKind = iota
+	// ObjectRemoved is sent when an object is removed, replaced or
+	// demoted to a placeholder.
+	ObjectRemoved
+	// InterfaceAdded is sent when an object starts exporting an
+	// interface.
+	InterfaceAdded
+	// InterfaceRemoved is sent when an object stops exporting an
+	// interface.
+	InterfaceRemoved
+	// ListenerAdded is sent when an object starts receiving the
+	// signals of an interface.
+	ListenerAdded
+	// ListenerRemoved is sent when an object stops receiving the
+	// signals of an interface.
+	ListenerRemoved
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case ObjectAdded:
+		return "ObjectAdded"
+	case ObjectRemoved:
+		return "ObjectRemoved"
+	case InterfaceAdded:
+		return "InterfaceAdded"
+	case InterfaceRemoved:
+		return "InterfaceRemoved"
+	case ListenerAdded:
+		return "ListenerAdded"
+	case ListenerRemoved:
+		return "ListenerRemoved"
+	}
+	return "Unknown"
+}
+
+// Event describes a change to the object tree. Interface is empty for
+// ObjectAdded and ObjectRemoved events. Placeholders and objects
+// served by a Fallback do not generate events, and neither do the
+// standard interfaces every object exports.
+type Event struct {
+	Kind      EventKind
+	Path      dbus.ObjectPath
+	Interface string
+}
+
+// A Subscription delivers the events of a tree to a callback.
+type Subscription struct {
+	hub    *eventHub
+	prefix string
+	fn     func(Event)
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []Event
+	closed bool
+}
+
+// Subscribe calls fn for every change made to o or the objects below
+// it. Events are delivered one at a time, in the order the changes
+// were made, from a goroutine owned by the subscription; fn may
+// modify the tree. Events are queued without bound, so a slow fn
+// delays delivery but never blocks changes to the tree.
+func (o *Object) Subscribe(fn func(Event)) *Subscription {
+	prefix := string(o.Path())
+	if prefix == "/" {
+		prefix = ""
+	}
+	s := &Subscription{
+		hub:    &o.getTree().events,
+		prefix: prefix,
+		fn:     fn,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.hub.add(s)
+	go s.run()
+	return s
+}
+
+// Close stops delivery. Events that have not been delivered yet are
+// dropped.
+func (s *Subscription) Close() {
+	s.hub.remove(s)
+	s.mu.Lock()
+	s.closed = true
+	s.queue = nil
+	s.mu.Unlock()
+	s.cond.Signal()
+}
+
+func (s *Subscription) matches(path dbus.ObjectPath) bool {
+	p := string(path)
+	return s.prefix == "" || p == s.prefix ||
+		strings.HasPrefix(p, s.prefix+"/")
+}
+
+func (s *Subscription) push(e Event) {
+	s.mu.Lock()
+	if !s.closed {
+		s.queue = append(s.queue, e)
+	}
+	s.mu.Unlock()
+	s.cond.Signal()
+}
+
+func (s *Subscription) run() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		e := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+		s.fn(e)
+	}
+}
+
+type eventHub struct {
+	mu    sync.Mutex
+	count int32
+	subs  map[*Subscription]struct{}
+}
+
+func (h *eventHub) add(s *Subscription) {
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[*Subscription]struct{})
+	}
+	h.subs[s] = struct{}{}
+	atomic.StoreInt32(&h.count, int32(len(h.subs)))
+	h.mu.Unlock()
+}
+
+func (h *eventHub) remove(s *Subscription) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	atomic.StoreInt32(&h.count, int32(len(h.subs)))
+	h.mu.Unlock()
+}
+
+func (h *eventHub) active() bool {
+	return atomic.LoadInt32(&h.count) > 0
+}
+
+func (h *eventHub) emit(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.matches(e.Path) {
+			s.push(e)
+		}
+	}
+}
+
+func (o *Object) emit(kind EventKind, iface string) {
+	if !o.getTree().events.active() {
+		return
+	}
+	o.getTree().events.emit(Event{
+		Kind:      kind,
+		Path:      o.Path(),
+		Interface: iface,
+	})
+}
+
+// emitAdded announces o together with its interfaces and listeners.
+func (o *Object) emitAdded() {
+	if !o.getTree().events.active() {
+		return
+	}
+	o.emit(ObjectAdded, "")
+	for _, name := range exportedInterfaces(o) {
+		o.emit(InterfaceAdded, name)
+	}
+	for _, name := range sortedNames(o.getListeners()) {
+		o.emit(ListenerAdded, name)
+	}
+}
+
+// emitRemoved announces that o, its interfaces and listeners are gone.
+func (o *Object) emitRemoved() {
+	if !o.getTree().events.active() {
+		return
+	}
+	for _, name := range exportedInterfaces(o) {
+		o.emit(InterfaceRemoved, name)
+	}
+	for _, name := range sortedNames(o.getListeners()) {
+		o.emit(ListenerRemoved, name)
+	}
+	o.emit(ObjectRemoved, "")
+}
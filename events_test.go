@@ -0,0 +1,147 @@
+package objtree
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func subscribe(o *Object) (*Subscription, chan Event) {
+	ch := make(chan Event, 64)
+	return o.Subscribe(func(e Event) { ch <- e }), ch
+}
+
+func expectEvents(t *testing.T, ch chan Event, expected ...Event) {
+	t.Helper()
+	var got []Event
+	for range expected {
+		select {
+		case e := <-ch:
+			got = append(got, e)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out; expected: %v got: %v", expected, got)
+		}
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected: %v got: %v", expected, got)
+	}
+	select {
+	case e := <-ch:
+		t.Fatal("unexpected event:", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSubscribeObjectEvents(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	sub, ch := subscribe(root)
+	defer sub.Close()
+	obj := root.NewObjectFromTable("/foo/bar", map[string]interface{}{
+		"CallMe":  func() string { return "hello, world" },
+		"Changed": func() {},
+	})
+	if err := obj.Implements("foo", (*testIface)(nil)); err != nil {
+		t.Fatal(err)
+	}
+	err := obj.ReceivesTable("sig", map[string]interface{}{
+		"Changed": func() {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.DeleteObject("/foo/bar")
+	expectEvents(t, ch,
+		Event{ObjectAdded, "/foo/bar", ""},
+		Event{InterfaceAdded, "/foo/bar", "foo"},
+		Event{ListenerAdded, "/foo/bar", "sig"},
+		Event{InterfaceRemoved, "/foo/bar", "foo"},
+		Event{ListenerRemoved, "/foo/bar", "sig"},
+		Event{ObjectRemoved, "/foo/bar", ""},
+	)
+}
+
+func TestSubscribeReplaceAndPromote(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.NewObject("/foo/bar", &testObj{})
+	sub, ch := subscribe(root)
+	defer sub.Close()
+	root.NewObject("/foo", &testObj{})
+	root.NewObject("/foo", &testObj{})
+	expectEvents(t, ch,
+		Event{ObjectAdded, "/foo", ""},
+		Event{ObjectRemoved, "/foo", ""},
+		Event{ObjectAdded, "/foo", ""},
+	)
+}
+
+func TestSubscribeSubtree(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	foo := root.NewObject("/foo", &testObj{})
+	sub, ch := subscribe(foo)
+	defer sub.Close()
+	root.NewObject("/foobar", &testObj{})
+	root.NewObject("/foo/bar", &testObj{})
+	expectEvents(t, ch, Event{ObjectAdded, "/foo/bar", ""})
+}
+
+func TestSubscribeIgnoresFallbackObjects(t *testing.T) {
+	var calls int32
+	root := newObjectFromImpl("", nil, nil, nil)
+	newTestFallback(root, &calls)
+	sub, ch := subscribe(root)
+	defer sub.Close()
+	if _, ok := lookupPath(root, "/users/alice"); !ok {
+		t.Fatal("expected to resolve object")
+	}
+	expectEvents(t, ch)
+}
+
+func TestSubscribeTx(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.NewObject("/old", &testObj{})
+	sub, ch := subscribe(root)
+	defer sub.Close()
+	tx := root.Begin()
+	obj, _ := tx.CreateObject("/foo", &testObj{}, CreateExclusive)
+	obj.Implements("foo", (*testIface)(nil))
+	tx.RemoveObject("/old")
+	select {
+	case e := <-ch:
+		t.Fatal("staged changes should not generate events:", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, ch,
+		Event{ObjectAdded, "/foo", ""},
+		Event{InterfaceAdded, "/foo", "foo"},
+		Event{ObjectRemoved, "/old", ""},
+	)
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	sub, ch := subscribe(root)
+	sub.Close()
+	root.NewObject("/foo", &testObj{})
+	expectEvents(t, ch)
+}
+
+func TestSubscribeCallbackMayModifyTree(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	done := make(chan struct{})
+	sub := root.Subscribe(func(e Event) {
+		if e.Kind == ObjectAdded && e.Path == "/foo" {
+			root.NewObject("/bar", &testObj{})
+			close(done)
+		}
+	})
+	defer sub.Close()
+	root.NewObject("/foo", &testObj{})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("callback did not run")
+	}
+}
@@ -0,0 +1,38 @@
+package objtree_test
+
+import (
+	"fmt"
+	"github.com/jsouthworth/objtree"
+	"github.com/jsouthworth/objtree/loopback"
+)
+
+type user struct {
+	name string
+}
+
+func (u *user) Name() string { return u.name }
+
+func ExampleObject_CreateObject() {
+	bus := loopback.New()
+	defer bus.Close()
+	mgr, err := objtree.NewBusManager(bus.DialHandler,
+		"com.example.Users")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, name := range []string{"alice", "bob.smith"} {
+		path := objtree.JoinPath("/com/example/users", name)
+		_, err := mgr.CreateObject(path, &user{name: name},
+			objtree.CreateExclusive)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println(path)
+	}
+	// Output:
+	// /com/example/users/alice
+	// /com/example/users/bob_2esmith
+}
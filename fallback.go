@@ -0,0 +1,425 @@
+package objtree
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/godbus/dbus"
+	"github.com/jsouthworth/objtree/internal/reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResolveFunc returns the implementation of the object at path, or
+// false if there is no such object.
+type ResolveFunc func(path dbus.ObjectPath) (interface{}, bool)
+
+// EnumerateFunc returns the names of the direct children of path.
+type EnumerateFunc func(path dbus.ObjectPath) []string
+
+// CachePolicy controls how long resolved objects are kept by a
+// Fallback.
+type CachePolicy struct {
+	// MaxObjects bounds the number of cached objects; the least
+	// recently used object is evicted first. Zero means no bound.
+	MaxObjects int
+	// TTL evicts objects that have not been looked up for the
+	// given duration. Zero means objects do not expire.
+	TTL time.Duration
+}
+
+// A Fallback serves objects below a path that were not created
+// explicitly. Objects are built on demand from the implementation
+// returned by the resolver and export every interface declared on
+// the Fallback. Fallback objects handle method calls and
+// introspection; they do not receive signals.
+type Fallback struct {
+	resolve ResolveFunc
+	mapfn   func(string) string
+
+	mu         sync.RWMutex
+	node       *Object
+	interfaces map[string]*reflect.InterfaceType
+	enumerate  EnumerateFunc
+
+	cache fallbackCache
+}
+
+// NewFallback registers resolve for all unknown paths below path. It
+// panics if path is not a valid object path.
+func (o *Object) NewFallback(
+	path dbus.ObjectPath,
+	resolve ResolveFunc,
+) *Fallback {
+	return o.NewFallbackMap(path, resolve,
+		func(in string) string {
+			return in
+		})
+}
+
+// NewFallbackMap is like NewFallback but maps the method names of the
+// resolved implementations like NewObjectMap.
+func (o *Object) NewFallbackMap(
+	path dbus.ObjectPath,
+	resolve ResolveFunc,
+	mapfn func(string) string,
+) *Fallback {
+	if err := ValidatePath(path); err != nil {
+		panic("objtree: " + err.Error())
+	}
+	tree := o.lockTree()
+	defer tree.Unlock()
+	node := o
+	if string(path) != "/" {
+		node = o.placeholderObject(pathToStringSlice(path))
+	}
+	f := &Fallback{
+		node:       node,
+		resolve:    resolve,
+		mapfn:      mapfn,
+		interfaces: make(map[string]*reflect.InterfaceType),
+	}
+	node.fallback.Store(f)
+	return f
+}
+
+func (o *Object) getFallback() *Fallback {
+	return o.fallback.Load().(*Fallback)
+}
+
+func (o *Object) hasFallback() bool {
+	return o.getFallback() != nil
+}
+
+// fallbackRef is a path left for a fallback to resolve.
+type fallbackRef struct {
+	f    *Fallback
+	path dbus.ObjectPath
+}
+
+// resolve looks up the path with the fallback, if there is one, as
+// lookup does. It calls the resolver, so the tree must not be locked.
+func (ref fallbackRef) resolve() (*Object, error) {
+	if ref.f == nil {
+		return nil, nil
+	}
+	return ref.f.lookup(ref.path)
+}
+
+// findFallback returns the closest fallback registered on o or one of
+// its ancestors along with the path, relative to o, it is to resolve.
+func (o *Object) findFallback(path []string) fallbackRef {
+	for node := o; node != nil; node = node.getParent() {
+		if f := node.getFallback(); f != nil {
+			full := string(o.Path())
+			if full == "/" {
+				full = ""
+			}
+			full += "/" + strings.Join(path, "/")
+			return fallbackRef{f: f, path: dbus.ObjectPath(full)}
+		}
+	}
+	return fallbackRef{}
+}
+
+func (f *Fallback) getNode() *Object {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.node
+}
+
+// setNode rebinds the fallback when the object it is attached to is
+// replaced.
+func (f *Fallback) setNode(node *Object) {
+	f.mu.Lock()
+	f.node = node
+	f.mu.Unlock()
+	f.Flush()
+}
+
+// Implements exports the methods making up the Go interface iface as
+// the D-Bus interface name on every object f resolves. Implementations
+// are checked when they are resolved; calls to an object whose
+// implementation lacks any of the methods fail with
+// org.freedesktop.DBus.Error.Failed and the mismatch is logged.
+func (f *Fallback) Implements(name string, iface interface{}) error {
+	return f.ImplementsMap(name, iface,
+		func(in string) string {
+			return in
+		})
+}
+
+func (f *Fallback) ImplementsMap(
+	name string,
+	iface interface{},
+	mapfn func(string) string,
+) error {
+	f.addInterface(name, reflect.NewInterfaceMapNames(iface, mapfn))
+	return nil
+}
+
+func (f *Fallback) ImplementsTable(
+	name string,
+	table map[string]interface{},
+) error {
+	f.addInterface(name, reflect.NewInterfaceFromTable(table))
+	return nil
+}
+
+func (f *Fallback) addInterface(name string, typ *reflect.InterfaceType) {
+	f.mu.Lock()
+	f.interfaces[name] = typ
+	f.mu.Unlock()
+	f.Flush()
+}
+
+// Enumerate sets the function used to list children for
+// introspection.
+func (f *Fallback) Enumerate(fn EnumerateFunc) {
+	f.mu.Lock()
+	f.enumerate = fn
+	f.mu.Unlock()
+}
+
+// Cache enables caching of resolved objects using policy. A nil
+// policy disables caching; objects are then resolved on every lookup.
+func (f *Fallback) Cache(policy *CachePolicy) {
+	f.cache.setPolicy(policy)
+}
+
+// Evict drops the cached object at path, if any.
+func (f *Fallback) Evict(path dbus.ObjectPath) {
+	f.cache.remove(path)
+}
+
+// Flush drops all cached objects.
+func (f *Fallback) Flush() {
+	f.cache.flush()
+}
+
+func (f *Fallback) children(path dbus.ObjectPath) []string {
+	f.mu.RLock()
+	enumerate := f.enumerate
+	f.mu.RUnlock()
+	if enumerate == nil {
+		return nil
+	}
+	return enumerate(path)
+}
+
+// lookup returns the object at path, or nil if there is none. If the
+// resolved implementation lacks the methods of an interface declared
+// on f the failure is logged and returned.
+func (f *Fallback) lookup(path dbus.ObjectPath) (*Object, error) {
+	if obj, ok := f.cache.get(path); ok {
+		return obj, nil
+	}
+	impl, ok := f.resolve(path)
+	if !ok {
+		if len(f.children(path)) == 0 {
+			return nil, nil
+		}
+		// Intermediate node, only useful for introspection
+		node := f.getNode()
+		obj := newObjectFromImpl(f.relativeName(node, path), nil,
+			node, node.getBus())
+		obj.fallback.Store(f)
+		return obj, nil
+	}
+	obj, err := f.newObject(path, impl)
+	if err != nil {
+		f.getNode().logger().Warn("objtree: cannot export resolved object",
+			"path", string(path), "error", err)
+		return nil, dbus.NewError(fdtFailed, []interface{}{
+			string(path) + ": " + err.Error(),
+		})
+	}
+	return f.cache.add(path, obj), nil
+}
+
+func (f *Fallback) newObject(
+	path dbus.ObjectPath,
+	impl interface{},
+) (*Object, error) {
+	node := f.getNode()
+	obj := newObjectFromImpl(f.relativeName(node, path),
+		reflect.NewObjectMapNames(impl, f.mapfn), node, node.getBus())
+	obj.fallback.Store(f)
+	interfaces := obj.getInterfaces()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for name, typ := range f.interfaces {
+		iface, err := obj.getImpl().AsInterface(typ)
+		if err != nil {
+			return nil, fmt.Errorf("the resolved implementation "+
+				"does not implement %s", name)
+		}
+		// resolved objects are not part of the tree, so their
+		// interfaces are added without generating events
+		interfaces[name] = &Interface{
+			name:   name,
+			impl:   iface,
+			object: obj,
+		}
+	}
+	obj.interfaces.value.Store(interfaces)
+	return obj, nil
+}
+
+// relativeName is the name of a resolved object relative to the
+// fallback node so that the object's path can be derived from it.
+func (f *Fallback) relativeName(node *Object, path dbus.ObjectPath) string {
+	prefix := string(node.Path())
+	if prefix != "/" {
+		prefix += "/"
+	}
+	return strings.TrimPrefix(string(path), prefix)
+}
+
+// failedObject stands in for a resolved object that could not be
+// exported. Every call made to it fails with err so that clients learn
+// why rather than being told the object does not exist.
+type failedObject struct {
+	err error
+}
+
+func (o failedObject) LookupInterface(string) (dbus.Interface, bool) {
+	return o, true
+}
+
+func (o failedObject) LookupMethod(string) (dbus.Method, bool) {
+	return o, true
+}
+
+func (o failedObject) DecodeArguments(
+	*dbus.Conn,
+	string,
+	*dbus.Message,
+	[]interface{},
+) ([]interface{}, error) {
+	return nil, o.err
+}
+
+func (o failedObject) Call(...interface{}) ([]interface{}, error) {
+	return nil, o.err
+}
+
+func (o failedObject) NumArguments() int             { return 0 }
+func (o failedObject) NumReturns() int               { return 0 }
+func (o failedObject) ArgumentValue(int) interface{} { return nil }
+func (o failedObject) ReturnValue(int) interface{}   { return nil }
+
+type fallbackCache struct {
+	mu      sync.Mutex
+	policy  *CachePolicy
+	entries map[dbus.ObjectPath]*list.Element
+	lru     list.List
+}
+
+type cacheEntry struct {
+	path dbus.ObjectPath
+	obj  *Object
+	used time.Time
+}
+
+func (c *fallbackCache) setPolicy(policy *CachePolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if policy != nil {
+		p := *policy
+		policy = &p
+	}
+	c.policy = policy
+	if policy == nil {
+		c.entries = nil
+		c.lru.Init()
+		return
+	}
+	if c.entries == nil {
+		c.entries = make(map[dbus.ObjectPath]*list.Element)
+	}
+	c.evict(time.Now())
+}
+
+func (c *fallbackCache) get(path dbus.ObjectPath) (*Object, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.policy == nil {
+		return nil, false
+	}
+	elem, ok := c.entries[path]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	now := time.Now()
+	if c.expired(entry, now) {
+		c.lru.Remove(elem)
+		delete(c.entries, path)
+		return nil, false
+	}
+	entry.used = now
+	c.lru.MoveToFront(elem)
+	return entry.obj, true
+}
+
+// add caches obj unless another lookup raced with this one and
+// cached an object first, in which case that object is returned.
+func (c *fallbackCache) add(path dbus.ObjectPath, obj *Object) *Object {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.policy == nil {
+		return obj
+	}
+	now := time.Now()
+	if elem, ok := c.entries[path]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.used = now
+		c.lru.MoveToFront(elem)
+		return entry.obj
+	}
+	c.entries[path] = c.lru.PushFront(&cacheEntry{
+		path: path,
+		obj:  obj,
+		used: now,
+	})
+	c.evict(now)
+	return obj
+}
+
+func (c *fallbackCache) remove(path dbus.ObjectPath) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[path]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, path)
+	}
+}
+
+func (c *fallbackCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		return
+	}
+	c.entries = make(map[dbus.ObjectPath]*list.Element)
+	c.lru.Init()
+}
+
+func (c *fallbackCache) expired(entry *cacheEntry, now time.Time) bool {
+	return c.policy.TTL > 0 && now.Sub(entry.used) > c.policy.TTL
+}
+
+// evict enforces the cache policy; the caller must hold c.mu.
+func (c *fallbackCache) evict(now time.Time) {
+	for elem := c.lru.Back(); elem != nil; elem = c.lru.Back() {
+		entry := elem.Value.(*cacheEntry)
+		overfull := c.policy.MaxObjects > 0 &&
+			c.lru.Len() > c.policy.MaxObjects
+		if !overfull && !c.expired(entry, now) {
+			return
+		}
+		c.lru.Remove(elem)
+		delete(c.entries, entry.path)
+	}
+}
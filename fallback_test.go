@@ -0,0 +1,365 @@
+package objtree
+
+import (
+	"fmt"
+	"github.com/godbus/dbus"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testUser struct {
+	name string
+}
+
+func (u *testUser) Name() string { return u.name }
+
+type testUserIface interface {
+	Name() string
+}
+
+func lookupPath(o *Object, path dbus.ObjectPath) (*Object, bool) {
+	return o.lookupObjectPath(pathToStringSlice(path))
+}
+
+func newTestFallback(root *Object, calls *int32) *Fallback {
+	f := root.NewFallback("/users",
+		func(path dbus.ObjectPath) (interface{}, bool) {
+			atomic.AddInt32(calls, 1)
+			name := strings.TrimPrefix(string(path), "/users/")
+			if strings.Contains(name, "/") || name == "nobody" {
+				return nil, false
+			}
+			return &testUser{name: name}, true
+		})
+	f.Implements("foo", (*testUserIface)(nil))
+	return f
+}
+
+func TestFallbackResolve(t *testing.T) {
+	var calls int32
+	root := newObjectFromImpl("", nil, nil, nil)
+	newTestFallback(root, &calls)
+	obj, ok := lookupPath(root, "/users/alice")
+	if !ok {
+		t.Fatal("expected to resolve object")
+	}
+	outs, err := obj.Call("foo", "Name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outs[0].(string) != "alice" {
+		t.Fatal("expected: alice got:", outs[0])
+	}
+	if obj.Path() != "/users/alice" {
+		t.Fatal("unexpected path:", obj.Path())
+	}
+}
+
+func TestFallbackResolveUnknown(t *testing.T) {
+	var calls int32
+	root := newObjectFromImpl("", nil, nil, nil)
+	newTestFallback(root, &calls)
+	if _, ok := lookupPath(root, "/users/nobody"); ok {
+		t.Fatal("unexpected object")
+	}
+	if _, ok := lookupPath(root, "/groups/nobody"); ok {
+		t.Fatal("unexpected object")
+	}
+}
+
+func TestFallbackExplicitObjectWins(t *testing.T) {
+	var calls int32
+	root := newObjectFromImpl("", nil, nil, nil)
+	newTestFallback(root, &calls)
+	root.NewObject("/users/admin", &testObj{})
+	obj, ok := lookupPath(root, "/users/admin")
+	if !ok {
+		t.Fatal("expected to find object")
+	}
+	if _, err := obj.Call("foo", "Name"); err == nil {
+		t.Fatal("expected explicit object")
+	}
+	if calls != 0 {
+		t.Fatal("resolver should not have been called")
+	}
+}
+
+func TestFallbackBelowExplicitObject(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.NewFallback("/users",
+		func(path dbus.ObjectPath) (interface{}, bool) {
+			return &testUser{name: string(path)}, true
+		}).Implements("foo", (*testUserIface)(nil))
+	root.NewObject("/users/admin", &testObj{})
+	obj, ok := lookupPath(root, "/users/admin/session")
+	if !ok {
+		t.Fatal("expected to resolve object")
+	}
+	outs, err := obj.Call("foo", "Name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outs[0].(string) != "/users/admin/session" {
+		t.Fatal("unexpected output:", outs[0])
+	}
+}
+
+func TestFallbackNotImplemented(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	f := root.NewFallback("/users",
+		func(path dbus.ObjectPath) (interface{}, bool) {
+			return &testObj{}, true
+		})
+	f.Implements("foo", (*testUserIface)(nil))
+	if _, ok := lookupPath(root, "/users/alice"); ok {
+		t.Fatal("object should not resolve")
+	}
+}
+
+func TestBusManagerFallbackNotImplemented(t *testing.T) {
+	bus, mgr, buf := newLoggingBusManager(t)
+	defer bus.Close()
+	mgr.NewFallback("/users",
+		func(path dbus.ObjectPath) (interface{}, bool) {
+			return &testObj{}, true
+		}).Implements("foo.bar", (*testUserIface)(nil))
+	client := newLoopbackClient(t, bus)
+	err := client.Object("com.github.jsouthworth.objtree.Test",
+		"/users/alice").Call("foo.bar.Name", 0).Err
+	dbusErr, ok := err.(dbus.Error)
+	if !ok || dbusErr.Name != fdtFailed ||
+		!strings.Contains(fmt.Sprint(dbusErr.Body...), "foo.bar") {
+		t.Fatal("expected Failed naming the interface got:", err)
+	}
+	buf.waitFor(t, "level=WARN", "cannot export resolved object",
+		"path=/users/alice")
+	if _, err := mgr.Call("/users/alice", "foo.bar", "Name"); err == nil {
+		t.Fatal("expected the call to fail")
+	}
+}
+
+func TestFallbackNoCache(t *testing.T) {
+	var calls int32
+	root := newObjectFromImpl("", nil, nil, nil)
+	newTestFallback(root, &calls)
+	lookupPath(root, "/users/alice")
+	lookupPath(root, "/users/alice")
+	if calls != 2 {
+		t.Fatal("expected 2 resolutions got:", calls)
+	}
+}
+
+func TestFallbackCache(t *testing.T) {
+	var calls int32
+	root := newObjectFromImpl("", nil, nil, nil)
+	f := newTestFallback(root, &calls)
+	f.Cache(&CachePolicy{})
+	obj, _ := lookupPath(root, "/users/alice")
+	obj2, _ := lookupPath(root, "/users/alice")
+	if obj != obj2 {
+		t.Fatal("expected cached object")
+	}
+	if calls != 1 {
+		t.Fatal("expected 1 resolution got:", calls)
+	}
+	f.Evict("/users/alice")
+	lookupPath(root, "/users/alice")
+	if calls != 2 {
+		t.Fatal("expected 2 resolutions got:", calls)
+	}
+}
+
+func TestFallbackCacheMaxObjects(t *testing.T) {
+	var calls int32
+	root := newObjectFromImpl("", nil, nil, nil)
+	f := newTestFallback(root, &calls)
+	f.Cache(&CachePolicy{MaxObjects: 2})
+	lookupPath(root, "/users/alice")
+	lookupPath(root, "/users/bob")
+	lookupPath(root, "/users/alice")
+	lookupPath(root, "/users/carol") // evicts bob
+	if calls != 3 {
+		t.Fatal("expected 3 resolutions got:", calls)
+	}
+	lookupPath(root, "/users/alice")
+	if calls != 3 {
+		t.Fatal("alice should still be cached")
+	}
+	lookupPath(root, "/users/bob")
+	if calls != 4 {
+		t.Fatal("bob should have been evicted")
+	}
+}
+
+func TestFallbackCacheTTL(t *testing.T) {
+	var calls int32
+	root := newObjectFromImpl("", nil, nil, nil)
+	f := newTestFallback(root, &calls)
+	f.Cache(&CachePolicy{TTL: 10 * time.Millisecond})
+	lookupPath(root, "/users/alice")
+	lookupPath(root, "/users/alice")
+	if calls != 1 {
+		t.Fatal("expected 1 resolution got:", calls)
+	}
+	time.Sleep(20 * time.Millisecond)
+	lookupPath(root, "/users/alice")
+	if calls != 2 {
+		t.Fatal("expected 2 resolutions got:", calls)
+	}
+}
+
+func TestFallbackIntrospect(t *testing.T) {
+	var calls int32
+	root := newObjectFromImpl("", nil, nil, nil)
+	f := newTestFallback(root, &calls)
+	f.Enumerate(func(path dbus.ObjectPath) []string {
+		if path == "/users" {
+			return []string{"alice", "bob"}
+		}
+		return nil
+	})
+	root.NewObject("/users/admin", &testObj{})
+	users, ok := lookupPath(root, "/users")
+	if !ok {
+		t.Fatal("expected to find object")
+	}
+	node := users.Introspect()
+	var names []string
+	for _, child := range node.Children {
+		names = append(names, child.Name)
+	}
+	if strings.Join(names, ",") != "admin,alice,bob" {
+		t.Fatal("unexpected children:", names)
+	}
+	alice, _ := lookupPath(root, "/users/alice")
+	found := false
+	for _, iface := range alice.Introspect().Interfaces {
+		if iface.Name == "foo" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected interface foo in introspection")
+	}
+}
+
+func TestFallbackIntermediateNode(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	f := root.NewFallback("/files",
+		func(path dbus.ObjectPath) (interface{}, bool) {
+			if path == "/files/dir/file" {
+				return &testUser{name: "file"}, true
+			}
+			return nil, false
+		})
+	f.Enumerate(func(path dbus.ObjectPath) []string {
+		switch path {
+		case "/files":
+			return []string{"dir"}
+		case "/files/dir":
+			return []string{"file"}
+		}
+		return nil
+	})
+	dir, ok := lookupPath(root, "/files/dir")
+	if !ok {
+		t.Fatal("expected intermediate node")
+	}
+	node := dir.Introspect()
+	if len(node.Interfaces) != 0 || len(node.Children) != 1 ||
+		node.Children[0].Name != "file" {
+		t.Fatalf("unexpected introspection: %+v", node)
+	}
+}
+
+func TestFallbackSurvivesChildDelete(t *testing.T) {
+	var calls int32
+	root := newObjectFromImpl("", nil, nil, nil)
+	newTestFallback(root, &calls)
+	root.NewObject("/users/admin", &testObj{})
+	root.DeleteObject("/users/admin")
+	if _, ok := lookupPath(root, "/users/alice"); !ok {
+		t.Fatal("fallback should survive deleting a child")
+	}
+	root.DeleteObject("/users")
+	if _, ok := lookupPath(root, "/users/alice"); ok {
+		t.Fatal("fallback should have been deleted")
+	}
+}
+
+func TestFallbackOnExistingObject(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.NewFallback("/users",
+		func(path dbus.ObjectPath) (interface{}, bool) {
+			return &testUser{name: "x"}, true
+		}).Implements("foo", (*testUserIface)(nil))
+	root.NewObject("/users", &testObj{})
+	if _, ok := lookupPath(root, "/users/alice"); !ok {
+		t.Fatal("fallback should survive replacing its node")
+	}
+}
+
+func TestBusManagerFallback(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	mgr.NewFallback("/users",
+		func(path dbus.ObjectPath) (interface{}, bool) {
+			return &testUser{name: string(path)}, true
+		}).Implements("foo.bar", (*testUserIface)(nil))
+	client := newLoopbackClient(t, bus)
+	var out string
+	err := client.Object("com.github.jsouthworth.objtree.Test",
+		"/users/alice").Call("foo.bar.Name", 0).Store(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "/users/alice" {
+		t.Fatal("expected: /users/alice got:", out)
+	}
+}
+
+func TestFallbackResolverChangesTree(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	f := mgr.NewFallback("/users",
+		func(path dbus.ObjectPath) (interface{}, bool) {
+			// promote the object to a real one on first use
+			user := &testUser{name: string(path)}
+			obj, err := mgr.CreateObject(path, user, CreateExclusive)
+			if err != nil {
+				return nil, false
+			}
+			obj.Implements("foo.bar", (*testUserIface)(nil))
+			return user, true
+		})
+	f.Implements("foo.bar", (*testUserIface)(nil))
+	f.Enumerate(func(path dbus.ObjectPath) []string {
+		mgr.NewObject("/enumerated", &testObj{})
+		return []string{"bob"}
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, ok := mgr.LookupObject("/users/alice"); !ok {
+			t.Error("expected to resolve object")
+		}
+		_, err := mgr.Call("/users", fdtIntrospectable, "Introspect")
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the fallback callbacks deadlocked on the tree lock")
+	}
+	if _, ok := mgr.LookupObject("/enumerated"); !ok {
+		t.Fatal("expected the enumerator to create an object")
+	}
+	obj, ok := mgr.LookupObject("/users/alice")
+	if !ok || obj.(*Object).getFallback() != nil {
+		t.Fatal("expected the resolver to create a real object")
+	}
+}
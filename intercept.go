@@ -0,0 +1,257 @@
+package objtree
+
+import (
+	"context"
+	"github.com/godbus/dbus"
+)
+
+// CallFunc makes the method call described by call.
+type CallFunc func(call *CallInfo) ([]interface{}, error)
+
+// An Interceptor wraps method calls. It may inspect or change call
+// before passing it to next, return an error without calling next to
+// short-circuit the call, or replace the results next returns. The
+// method that is eventually called is fixed; only changes to Args are
+// seen by it.
+type Interceptor func(call *CallInfo, next CallFunc) ([]interface{}, error)
+
+// SignalInfo describes the delivery of a signal to a listener. Path is
+// where the signal was emitted and Receiver the path of the listening
+// object. Context and Trace are as for CallInfo.
+type SignalInfo struct {
+	Context   context.Context
+	Trace     map[string]string
+	Sender    string
+	Path      dbus.ObjectPath
+	Receiver  dbus.ObjectPath
+	Interface string
+	Member    string
+	Args      []interface{}
+}
+
+// SignalFunc invokes the handler of the signal described by sig and
+// returns the error the handler returned, if any.
+type SignalFunc func(sig *SignalInfo) error
+
+// A SignalInterceptor wraps the delivery of signals to listeners. It
+// may inspect or change sig before passing it to next, drop the
+// signal by not calling next, or observe the handler's error. As with
+// Interceptor only changes to Args are seen by the handler.
+type SignalInterceptor func(sig *SignalInfo, next SignalFunc) error
+
+// interceptors are the interceptors and Queues registered on an
+// object. The struct is replaced as a whole when one is added.
+type interceptors struct {
+	subtree []Interceptor
+	object  []Interceptor
+	ifaces  map[string][]Interceptor
+
+	signalSubtree []SignalInterceptor
+	signalObject  []SignalInterceptor
+	signalIfaces  map[string][]SignalInterceptor
+
+	queue        *Queue
+	subtreeQueue *Queue
+}
+
+func (o *Object) getInterceptors() *interceptors {
+	return o.interceptors.Load().(*interceptors)
+}
+
+func (o *Object) updateInterceptors(fn func(*interceptors)) {
+	o.interceptors.Update(func(value interface{}) interface{} {
+		ic := &interceptors{
+			ifaces:       make(map[string][]Interceptor),
+			signalIfaces: make(map[string][]SignalInterceptor),
+		}
+		if old := value.(*interceptors); old != nil {
+			ic.subtree = old.subtree
+			ic.object = old.object
+			for name, chain := range old.ifaces {
+				ic.ifaces[name] = chain
+			}
+			ic.signalSubtree = old.signalSubtree
+			ic.signalObject = old.signalObject
+			for name, chain := range old.signalIfaces {
+				ic.signalIfaces[name] = chain
+			}
+			ic.queue = old.queue
+			ic.subtreeQueue = old.subtreeQueue
+		}
+		fn(ic)
+		return ic
+	})
+}
+
+// Intercept adds i to the interceptors of every method call the
+// manager handles, whether it comes from the bus or from Call.
+func (mgr *BusManager) Intercept(i Interceptor) {
+	mgr.InterceptSubtree(i)
+}
+
+// InterceptSubtree adds i to the interceptors of o and of every object
+// below it, including those served by a Fallback and those added
+// later.
+func (o *Object) InterceptSubtree(i Interceptor) {
+	o.updateInterceptors(func(ic *interceptors) {
+		ic.subtree = appendInterceptor(ic.subtree, i)
+	})
+}
+
+// Intercept adds i to the interceptors of the method calls made on o.
+func (o *Object) Intercept(i Interceptor) {
+	o.updateInterceptors(func(ic *interceptors) {
+		ic.object = appendInterceptor(ic.object, i)
+	})
+}
+
+// InterceptInterface adds i to the interceptors of the method calls
+// made on the D-Bus interface name of o.
+func (o *Object) InterceptInterface(name string, i Interceptor) {
+	o.updateInterceptors(func(ic *interceptors) {
+		ic.ifaces[name] = appendInterceptor(ic.ifaces[name], i)
+	})
+}
+
+// appendInterceptor never shares the backing array of chain, which
+// may still be in use by an older interceptors value.
+func appendInterceptor(chain []Interceptor, i Interceptor) []Interceptor {
+	out := make([]Interceptor, len(chain), len(chain)+1)
+	copy(out, chain)
+	return append(out, i)
+}
+
+// InterceptSignals adds i to the interceptors of every signal
+// delivered to a listener in the manager's tree.
+func (mgr *BusManager) InterceptSignals(i SignalInterceptor) {
+	mgr.InterceptSignalsSubtree(i)
+}
+
+// InterceptSignalsSubtree adds i to the interceptors of signals
+// delivered to o and to every object below it.
+func (o *Object) InterceptSignalsSubtree(i SignalInterceptor) {
+	o.updateInterceptors(func(ic *interceptors) {
+		ic.signalSubtree = appendSignalInterceptor(ic.signalSubtree, i)
+	})
+}
+
+// InterceptSignals adds i to the interceptors of signals delivered to
+// o.
+func (o *Object) InterceptSignals(i SignalInterceptor) {
+	o.updateInterceptors(func(ic *interceptors) {
+		ic.signalObject = appendSignalInterceptor(ic.signalObject, i)
+	})
+}
+
+// InterceptSignalInterface adds i to the interceptors of the signals
+// of the D-Bus interface name delivered to o.
+func (o *Object) InterceptSignalInterface(name string, i SignalInterceptor) {
+	o.updateInterceptors(func(ic *interceptors) {
+		ic.signalIfaces[name] = appendSignalInterceptor(
+			ic.signalIfaces[name], i)
+	})
+}
+
+func appendSignalInterceptor(
+	chain []SignalInterceptor,
+	i SignalInterceptor,
+) []SignalInterceptor {
+	out := make([]SignalInterceptor, len(chain), len(chain)+1)
+	copy(out, chain)
+	return append(out, i)
+}
+
+// inheritInterceptors copies the subtree interceptors and Queue of an
+// object that o replaces, since they apply to the children o adopts.
+func (o *Object) inheritInterceptors(old *Object) {
+	ic := old.getInterceptors()
+	if ic == nil || (len(ic.subtree) == 0 &&
+		len(ic.signalSubtree) == 0 && ic.subtreeQueue == nil) {
+		return
+	}
+	o.updateInterceptors(func(mine *interceptors) {
+		mine.subtree = append(append([]Interceptor(nil),
+			ic.subtree...), mine.subtree...)
+		mine.signalSubtree = append(append([]SignalInterceptor(nil),
+			ic.signalSubtree...), mine.signalSubtree...)
+		if mine.subtreeQueue == nil {
+			mine.subtreeQueue = ic.subtreeQueue
+		}
+	})
+}
+
+// interceptorChain returns the interceptors of a call to iface on o,
+// outermost first: subtree interceptors from the root down, then
+// those of o and finally those of the interface.
+func (o *Object) interceptorChain(iface string) []Interceptor {
+	var nodes []*Object
+	for node := o; node != nil; node = node.getParent() {
+		nodes = append(nodes, node)
+	}
+	var chain []Interceptor
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if ic := nodes[i].getInterceptors(); ic != nil {
+			chain = append(chain, ic.subtree...)
+		}
+	}
+	if ic := o.getInterceptors(); ic != nil {
+		chain = append(chain, ic.object...)
+		chain = append(chain, ic.ifaces[iface]...)
+	}
+	return chain
+}
+
+// signalInterceptorChain is interceptorChain for signals.
+func (o *Object) signalInterceptorChain(iface string) []SignalInterceptor {
+	var nodes []*Object
+	for node := o; node != nil; node = node.getParent() {
+		nodes = append(nodes, node)
+	}
+	var chain []SignalInterceptor
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if ic := nodes[i].getInterceptors(); ic != nil {
+			chain = append(chain, ic.signalSubtree...)
+		}
+	}
+	if ic := o.getInterceptors(); ic != nil {
+		chain = append(chain, ic.signalObject...)
+		chain = append(chain, ic.signalIfaces[iface]...)
+	}
+	return chain
+}
+
+func runSignalInterceptors(
+	chain []SignalInterceptor,
+	sig *SignalInfo,
+	last SignalFunc,
+) error {
+	if len(chain) == 0 {
+		return last(sig)
+	}
+	return chain[0](sig, func(sig *SignalInfo) error {
+		return runSignalInterceptors(chain[1:], sig, last)
+	})
+}
+
+func runInterceptors(
+	chain []Interceptor,
+	call *CallInfo,
+	last CallFunc,
+) ([]interface{}, error) {
+	if len(chain) == 0 {
+		return last(call)
+	}
+	return chain[0](call, func(call *CallInfo) ([]interface{}, error) {
+		return runInterceptors(chain[1:], call, last)
+	})
+}
+
+// path returns the path the method was called on.
+func (method *Method) path() dbus.ObjectPath {
+	if method.message != nil {
+		header := method.message.Headers[dbus.FieldPath]
+		path, _ := header.Value().(dbus.ObjectPath)
+		return path
+	}
+	return method.object.Path()
+}
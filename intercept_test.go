@@ -0,0 +1,200 @@
+package objtree
+
+import (
+	"errors"
+	"github.com/godbus/dbus"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func recordingInterceptor(name string, calls *[]string) Interceptor {
+	return func(call *CallInfo, next CallFunc) ([]interface{}, error) {
+		*calls = append(*calls, name)
+		return next(call)
+	}
+}
+
+func TestInterceptorOrder(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	var calls []string
+	root.InterceptSubtree(recordingInterceptor("root", &calls))
+	obj := root.NewObject("/foo/bar", &testObj{})
+	obj.Implements("foo", (*testIface)(nil))
+	obj.InterceptInterface("foo", recordingInterceptor("iface", &calls))
+	obj.Intercept(recordingInterceptor("object", &calls))
+	foo, _ := root.LookupObject("foo")
+	foo.InterceptSubtree(recordingInterceptor("subtree", &calls))
+	obj.InterceptInterface("other", recordingInterceptor("other", &calls))
+	outs, err := obj.Call("foo", "CallMe")
+	if err != nil || outs[0].(string) != "hello, world" {
+		t.Fatal("unexpected result:", outs, err)
+	}
+	expected := []string{"root", "subtree", "object", "iface"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatal("expected:", expected, "got:", calls)
+	}
+}
+
+func TestInterceptorShortCircuit(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	called := false
+	obj := root.NewObjectFromTable("/foo", map[string]interface{}{
+		"Echo": func(in string) string {
+			called = true
+			return in
+		},
+	})
+	obj.ImplementsTable("foo", map[string]interface{}{
+		"Echo": func(string) string { return "" },
+	})
+	denied := errors.New("denied")
+	obj.Intercept(func(call *CallInfo, next CallFunc) ([]interface{}, error) {
+		return nil, denied
+	})
+	if _, err := obj.Call("foo", "Echo", "hello"); err != denied {
+		t.Fatal("expected the interceptor's error got:", err)
+	}
+	if called {
+		t.Fatal("the method should not have been called")
+	}
+}
+
+func TestInterceptorAltersCall(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	table := map[string]interface{}{
+		"Echo": func(in string) string { return in },
+	}
+	obj := root.NewObjectFromTable("/foo", table)
+	obj.ImplementsTable("foo", table)
+	var got *CallInfo
+	obj.Intercept(func(call *CallInfo, next CallFunc) ([]interface{}, error) {
+		got = call
+		call.Args[0] = strings.ToUpper(call.Args[0].(string))
+		outs, err := next(call)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{outs[0].(string) + "!"}, nil
+	})
+	outs, err := obj.Call("foo", "Echo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outs[0].(string) != "HELLO!" {
+		t.Fatal("unexpected result:", outs[0])
+	}
+	if got.Path != "/foo" || got.Interface != "foo" ||
+		got.Member != "Echo" || got.Sender != "" {
+		t.Fatal("unexpected call info:", got)
+	}
+}
+
+func TestInterceptorKeptOnReplace(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	var calls []string
+	obj := root.NewObject("/foo", &testObj{})
+	obj.InterceptSubtree(recordingInterceptor("subtree", &calls))
+	obj.Intercept(recordingInterceptor("object", &calls))
+	child := root.NewObject("/foo/child", &testObj{})
+	child.Implements("foo", (*testIface)(nil))
+	root.NewObject("/foo", &testObj{})
+	if _, err := child.Call("foo", "CallMe"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(calls, []string{"subtree"}) {
+		t.Fatal("subtree interceptors should survive replacement got:", calls)
+	}
+}
+
+func TestLoopbackBusManagerIntercept(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	obj := mgr.NewObject("/foo/bar", &testObj{})
+	obj.Implements("com.github.jsouthworth.objtree.Test", (*testIface)(nil))
+	infos := make(chan CallInfo, 1)
+	mgr.Intercept(func(call *CallInfo, next CallFunc) ([]interface{}, error) {
+		infos <- *call
+		return []interface{}{"intercepted"}, nil
+	})
+	client := newLoopbackClient(t, bus)
+	var out string
+	err := client.Object("com.github.jsouthworth.objtree.Test", "/foo/bar").
+		Call("com.github.jsouthworth.objtree.Test.CallMe", 0).Store(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "intercepted" {
+		t.Fatal("expected the interceptor's result got:", out)
+	}
+	info := <-infos
+	if info.Sender != client.Names()[0] || info.Path != "/foo/bar" ||
+		info.Member != "CallMe" {
+		t.Fatal("unexpected call info:", info)
+	}
+}
+
+func TestSignalInterceptors(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	received := make(chan string, 2)
+	handlers := map[string]interface{}{
+		"Changed": func(in string) {
+			received <- in
+		},
+	}
+	obj := root.NewObjectFromTable("/foo/bar", handlers)
+	obj.ReceivesTable("com.example.Test", handlers)
+	order := make(chan string, 8)
+	record := func(name string) SignalInterceptor {
+		return func(sig *SignalInfo, next SignalFunc) error {
+			order <- name
+			return next(sig)
+		}
+	}
+	root.InterceptSignalsSubtree(record("root"))
+	obj.InterceptSignals(record("object"))
+	obj.InterceptSignalInterface("com.example.Test",
+		func(sig *SignalInfo, next SignalFunc) error {
+			order <- "iface"
+			if sig.Receiver != "/foo/bar" || sig.Path != "/emitter" ||
+				sig.Member != "Changed" {
+				t.Error("unexpected signal info:", sig)
+			}
+			if sig.Args[0].(string) == "drop" {
+				return nil
+			}
+			sig.Args[0] = strings.ToUpper(sig.Args[0].(string))
+			return next(sig)
+		})
+	obj.InterceptSignals(record("object2"))
+	deliver := func(arg string) {
+		root.DeliverSignal("com.example.Test", "Changed", &dbus.Signal{
+			Path: "/emitter",
+			Body: []interface{}{arg},
+		})
+	}
+	deliver("hello")
+	select {
+	case got := <-received:
+		if got != "HELLO" {
+			t.Fatal("expected the altered argument got:", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for signal")
+	}
+	var calls []string
+	for i := 0; i < 4; i++ {
+		calls = append(calls, <-order)
+	}
+	expected := []string{"root", "object", "object2", "iface"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatal("expected:", expected, "got:", calls)
+	}
+	deliver("drop")
+	select {
+	case got := <-received:
+		t.Fatal("the signal should have been dropped got:", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
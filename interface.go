@@ -8,8 +8,9 @@ import (
 )
 
 type Interface struct {
-	name string
-	impl *reflect.Interface
+	name   string
+	impl   *reflect.Interface
+	object *Object
 }
 
 func (intf *Interface) lookupMethod(name string) (*Method, bool) {
@@ -21,8 +22,10 @@ func (intf *Interface) lookupMethod(name string) (*Method, bool) {
 	// Make a new method with the immutable fields from the stored
 	// method.
 	new_method := &Method{
-		impl: method,
-		name: name,
+		impl:   method,
+		name:   name,
+		iface:  intf.name,
+		object: intf.object,
 	}
 	return new_method, ok
 }
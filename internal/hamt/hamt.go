@@ -0,0 +1,233 @@
+// Package hamt implements an immutable map from strings to values as a
+// hash array mapped trie. Updates return a new map that shares all
+// but O(log N) nodes with the old one, so a map can be published
+// through an atomic.Value and read without locks while writers build
+// the next version.
+package hamt
+
+import (
+	"math/bits"
+)
+
+const (
+	chunk    = 5
+	width    = 1 << chunk
+	mask     = width - 1
+	maxShift = 32 // hashes are exhausted at this depth
+)
+
+// Map is an immutable map. The zero value and nil are empty maps.
+type Map struct {
+	root *node
+	size int
+}
+
+// node is either a bitmap indexed branch or, once the hash is
+// exhausted, a list of colliding entries.
+type node struct {
+	bitmap  uint32
+	entries []entry
+}
+
+type entry struct {
+	key   string
+	value interface{}
+	child *node
+}
+
+func hash(key string) uint32 {
+	// FNV-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// Len returns the number of entries in m.
+func (m *Map) Len() int {
+	if m == nil {
+		return 0
+	}
+	return m.size
+}
+
+// Get returns the value stored for key.
+func (m *Map) Get(key string) (interface{}, bool) {
+	if m == nil || m.root == nil {
+		return nil, false
+	}
+	h := hash(key)
+	n := m.root
+	for shift := uint(0); ; shift += chunk {
+		if shift >= maxShift {
+			for _, e := range n.entries {
+				if e.key == key {
+					return e.value, true
+				}
+			}
+			return nil, false
+		}
+		bit := uint32(1) << ((h >> shift) & mask)
+		if n.bitmap&bit == 0 {
+			return nil, false
+		}
+		e := &n.entries[index(n.bitmap, bit)]
+		if e.child == nil {
+			if e.key == key {
+				return e.value, true
+			}
+			return nil, false
+		}
+		n = e.child
+	}
+}
+
+// Set returns a map with key set to value.
+func (m *Map) Set(key string, value interface{}) *Map {
+	var root *node
+	size := 0
+	if m != nil {
+		root, size = m.root, m.size
+	}
+	e := entry{key: key, value: value}
+	root, added := set(root, 0, hash(key), e)
+	if added {
+		size++
+	}
+	return &Map{root: root, size: size}
+}
+
+// Delete returns a map without key.
+func (m *Map) Delete(key string) *Map {
+	if m == nil || m.root == nil {
+		return m
+	}
+	root, removed := del(m.root, 0, hash(key), key)
+	if !removed {
+		return m
+	}
+	return &Map{root: root, size: m.size - 1}
+}
+
+// Range calls fn for each entry in an unspecified order until fn
+// returns false.
+func (m *Map) Range(fn func(key string, value interface{}) bool) {
+	if m == nil || m.root == nil {
+		return
+	}
+	walk(m.root, fn)
+}
+
+func walk(n *node, fn func(string, interface{}) bool) bool {
+	for i := range n.entries {
+		e := &n.entries[i]
+		if e.child != nil {
+			if !walk(e.child, fn) {
+				return false
+			}
+			continue
+		}
+		if !fn(e.key, e.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func index(bitmap, bit uint32) int {
+	return bits.OnesCount32(bitmap & (bit - 1))
+}
+
+func set(n *node, shift uint, h uint32, e entry) (*node, bool) {
+	if n == nil {
+		n = &node{}
+	}
+	if shift >= maxShift {
+		entries := make([]entry, len(n.entries), len(n.entries)+1)
+		copy(entries, n.entries)
+		for i := range entries {
+			if entries[i].key == e.key {
+				entries[i] = e
+				return &node{entries: entries}, false
+			}
+		}
+		return &node{entries: append(entries, e)}, true
+	}
+	bit := uint32(1) << ((h >> shift) & mask)
+	idx := index(n.bitmap, bit)
+	if n.bitmap&bit == 0 {
+		entries := make([]entry, len(n.entries)+1)
+		copy(entries, n.entries[:idx])
+		entries[idx] = e
+		copy(entries[idx+1:], n.entries[idx:])
+		return &node{bitmap: n.bitmap | bit, entries: entries}, true
+	}
+	entries := make([]entry, len(n.entries))
+	copy(entries, n.entries)
+	old := entries[idx]
+	added := false
+	switch {
+	case old.child != nil:
+		entries[idx].child, added = set(old.child, shift+chunk, h, e)
+	case old.key == e.key:
+		entries[idx] = e
+	default:
+		child, _ := set(nil, shift+chunk, hash(old.key), old)
+		child, _ = set(child, shift+chunk, h, e)
+		entries[idx] = entry{child: child}
+		added = true
+	}
+	return &node{bitmap: n.bitmap, entries: entries}, added
+}
+
+func del(n *node, shift uint, h uint32, key string) (*node, bool) {
+	if shift >= maxShift {
+		for i := range n.entries {
+			if n.entries[i].key == key {
+				return without(n, i, 0), true
+			}
+		}
+		return n, false
+	}
+	bit := uint32(1) << ((h >> shift) & mask)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	idx := index(n.bitmap, bit)
+	old := n.entries[idx]
+	if old.child == nil {
+		if old.key != key {
+			return n, false
+		}
+		return without(n, idx, bit), true
+	}
+	child, removed := del(old.child, shift+chunk, h, key)
+	if !removed {
+		return n, false
+	}
+	if child == nil {
+		return without(n, idx, bit), true
+	}
+	entries := make([]entry, len(n.entries))
+	copy(entries, n.entries)
+	if len(child.entries) == 1 && child.entries[0].child == nil {
+		// keep the trie canonical by pulling single entries up
+		entries[idx] = child.entries[0]
+	} else {
+		entries[idx].child = child
+	}
+	return &node{bitmap: n.bitmap, entries: entries}, true
+}
+
+// without returns n minus the entry at idx, or nil if it was the last.
+func without(n *node, idx int, bit uint32) *node {
+	if len(n.entries) == 1 {
+		return nil
+	}
+	entries := make([]entry, 0, len(n.entries)-1)
+	entries = append(entries, n.entries[:idx]...)
+	entries = append(entries, n.entries[idx+1:]...)
+	return &node{bitmap: n.bitmap &^ bit, entries: entries}
+}
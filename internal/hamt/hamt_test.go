@@ -0,0 +1,146 @@
+package hamt
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func checkMap(t *testing.T, m *Map, model map[string]int) {
+	t.Helper()
+	if m.Len() != len(model) {
+		t.Fatalf("expected %d entries got: %d", len(model), m.Len())
+	}
+	for key, want := range model {
+		got, ok := m.Get(key)
+		if !ok || got.(int) != want {
+			t.Fatalf("%s: expected %d got: %v %v", key, want, got, ok)
+		}
+	}
+	seen := 0
+	m.Range(func(key string, value interface{}) bool {
+		if model[key] != value.(int) {
+			t.Fatalf("%s: unexpected value %v", key, value)
+		}
+		seen++
+		return true
+	})
+	if seen != len(model) {
+		t.Fatalf("expected to range over %d entries got: %d", len(model), seen)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	var m *Map
+	if m.Len() != 0 {
+		t.Fatal("expected empty map")
+	}
+	if _, ok := m.Get("foo"); ok {
+		t.Fatal("unexpected entry")
+	}
+	if m.Delete("foo") != m {
+		t.Fatal("deleting from an empty map should not allocate")
+	}
+	m = m.Set("foo", 1).Delete("foo")
+	if m.Len() != 0 {
+		t.Fatal("expected empty map")
+	}
+}
+
+func TestRandomOperations(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	model := make(map[string]int)
+	var m *Map
+	for i := 0; i < 20000; i++ {
+		key := strconv.Itoa(rnd.Intn(5000))
+		if rnd.Intn(3) == 0 {
+			delete(model, key)
+			m = m.Delete(key)
+		} else {
+			model[key] = i
+			m = m.Set(key, i)
+		}
+	}
+	checkMap(t, m, model)
+	for key := range model {
+		m = m.Delete(key)
+	}
+	if m.Len() != 0 || m.root != nil {
+		t.Fatal("expected empty map")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	var m *Map
+	for i := 0; i < 1000; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+	m2 := m.Set("0", -1).Delete("1").Set("new", 1)
+	model := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		model[strconv.Itoa(i)] = i
+	}
+	checkMap(t, m, model)
+	model["0"] = -1
+	delete(model, "1")
+	model["new"] = 1
+	checkMap(t, m2, model)
+}
+
+func findCollision() (string, string) {
+	seen := make(map[uint32]string)
+	for i := 0; ; i++ {
+		key := fmt.Sprint("key", i)
+		h := hash(key)
+		if other, ok := seen[h]; ok {
+			return other, key
+		}
+		seen[h] = key
+	}
+}
+
+func TestCollisions(t *testing.T) {
+	a, b := findCollision()
+	m := (*Map)(nil).Set(a, 1).Set(b, 2).Set("other", 3)
+	checkMap(t, m, map[string]int{a: 1, b: 2, "other": 3})
+	m = m.Set(b, 4)
+	checkMap(t, m, map[string]int{a: 1, b: 4, "other": 3})
+	m = m.Delete(a)
+	checkMap(t, m, map[string]int{b: 4, "other": 3})
+	m = m.Delete(b)
+	checkMap(t, m, map[string]int{"other": 3})
+}
+
+func TestRangeStop(t *testing.T) {
+	var m *Map
+	for i := 0; i < 100; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+	calls := 0
+	m.Range(func(string, interface{}) bool {
+		calls++
+		return calls < 10
+	})
+	if calls != 10 {
+		t.Fatal("expected range to stop after 10 entries got:", calls)
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	var m *Map
+	for i := 0; i < b.N; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	var m *Map
+	for i := 0; i < 100000; i++ {
+		m = m.Set(strconv.Itoa(i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Get(strconv.Itoa(i % 100000))
+	}
+}
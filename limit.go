@@ -0,0 +1,225 @@
+package objtree
+
+import (
+	"github.com/godbus/dbus"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const fdtLimitsExceeded = fdtDBusName + ".Error.LimitsExceeded"
+
+// Limits bound the method calls a single client may make. Zero fields
+// do not limit anything.
+type Limits struct {
+	// MaxInFlight is the number of calls that may run at once.
+	MaxInFlight int
+	// Rate is the sustained number of calls allowed per second and
+	// Burst the number that may be made at once after a quiet period.
+	// Burst defaults to Rate rounded up.
+	Rate  float64
+	Burst int
+}
+
+func (l Limits) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// LimitStats counts the calls a Limiter has seen.
+type LimitStats struct {
+	Allowed          uint64
+	RejectedInFlight uint64
+	RejectedRate     uint64
+	InFlight         int
+}
+
+func (s *LimitStats) rejected(inFlight bool) {
+	if inFlight {
+		s.RejectedInFlight++
+	} else {
+		s.RejectedRate++
+	}
+}
+
+// A Limiter rejects method calls received from the bus that exceed
+// per client limits with org.freedesktop.DBus.Error.LimitsExceeded.
+// Each client has one set of limits covering all of its calls and,
+// for methods given their own limits, one per method. Install it with
+//
+//	mgr.Intercept(limiter.Intercept)
+//
+// or at a narrower scope. Calls made directly through Call have no
+// sender and are not limited.
+type Limiter struct {
+	mgr *BusManager
+	now func() time.Time
+
+	mu      sync.Mutex
+	limits  Limits
+	methods map[string]Limits
+	senders map[string]*senderUsage
+	stats   LimitStats
+}
+
+type senderUsage struct {
+	all     usage
+	methods map[string]*usage
+	stats   LimitStats
+}
+
+// usage tracks the calls made against one set of limits as a count of
+// running calls and a token bucket.
+type usage struct {
+	inFlight int
+	tokens   float64
+	last     time.Time
+}
+
+// NewLimiter returns a Limiter applying limits to every client of mgr.
+func NewLimiter(mgr *BusManager, limits Limits) *Limiter {
+	l := &Limiter{
+		mgr:     mgr,
+		now:     time.Now,
+		limits:  limits,
+		methods: make(map[string]Limits),
+		senders: make(map[string]*senderUsage),
+	}
+	mgr.trackNames(l)
+	return l
+}
+
+// SetMethodLimits gives calls to member of iface limits of their own,
+// applied per client in addition to the client wide ones.
+func (l *Limiter) SetMethodLimits(iface, member string, limits Limits) {
+	l.mu.Lock()
+	l.methods[iface+"."+member] = limits
+	l.mu.Unlock()
+}
+
+// Close stops l from tracking the clients on the bus and drops their
+// usage. It can still be used.
+func (l *Limiter) Close() {
+	l.mgr.untrackNames(l)
+	l.mu.Lock()
+	l.senders = make(map[string]*senderUsage)
+	l.mu.Unlock()
+}
+
+// Stats returns the counters of all calls l has seen.
+func (l *Limiter) Stats() LimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// SenderStats returns the counters of the calls made by the client
+// with the unique name sender while it is connected.
+func (l *Limiter) SenderStats(sender string) LimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := l.senders[sender]; ok {
+		return s.stats
+	}
+	return LimitStats{}
+}
+
+// Intercept is an Interceptor enforcing l's limits.
+func (l *Limiter) Intercept(call *CallInfo, next CallFunc) ([]interface{}, error) {
+	if call.Sender == "" {
+		return next(call)
+	}
+	release, err := l.acquire(call)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return next(call)
+}
+
+func (l *Limiter) acquire(call *CallInfo) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := call.Interface + "." + call.Member
+	s, ok := l.senders[call.Sender]
+	if !ok {
+		s = &senderUsage{methods: make(map[string]*usage)}
+		l.senders[call.Sender] = s
+	}
+	now := l.now()
+	checks := []struct {
+		u      *usage
+		limits Limits
+	}{{&s.all, l.limits}}
+	if limits, ok := l.methods[key]; ok {
+		u, ok := s.methods[key]
+		if !ok {
+			u = new(usage)
+			s.methods[key] = u
+		}
+		checks = append(checks, struct {
+			u      *usage
+			limits Limits
+		}{u, limits})
+	}
+	for _, c := range checks {
+		if c.limits.MaxInFlight > 0 && c.u.inFlight >= c.limits.MaxInFlight {
+			l.stats.rejected(true)
+			s.stats.rejected(true)
+			return nil, limitsExceeded(call.Sender + " has " +
+				strconv.Itoa(c.u.inFlight) + " calls in progress")
+		}
+		if c.limits.Rate > 0 && c.u.refill(now, c.limits) < 1 {
+			l.stats.rejected(false)
+			s.stats.rejected(false)
+			return nil, limitsExceeded(call.Sender +
+				" is making calls too quickly")
+		}
+	}
+	for _, c := range checks {
+		c.u.inFlight++
+		if c.limits.Rate > 0 {
+			c.u.tokens--
+		}
+	}
+	l.stats.Allowed++
+	l.stats.InFlight++
+	s.stats.Allowed++
+	s.stats.InFlight++
+	return func() {
+		l.mu.Lock()
+		for _, c := range checks {
+			c.u.inFlight--
+		}
+		l.stats.InFlight--
+		s.stats.InFlight--
+		l.mu.Unlock()
+	}, nil
+}
+
+// refill tops up the bucket for the time passed since it was last
+// used and returns the tokens available.
+func (u *usage) refill(now time.Time, limits Limits) float64 {
+	burst := limits.burst()
+	if u.last.IsZero() {
+		u.tokens = burst
+	} else {
+		u.tokens += now.Sub(u.last).Seconds() * limits.Rate
+		u.tokens = math.Min(u.tokens, burst)
+	}
+	u.last = now
+	return u.tokens
+}
+
+func (l *Limiter) forget(name string) {
+	l.mu.Lock()
+	delete(l.senders, name)
+	l.mu.Unlock()
+}
+
+func limitsExceeded(text string) *dbus.Error {
+	return dbus.NewError(fdtLimitsExceeded, []interface{}{text})
+}
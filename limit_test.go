@@ -0,0 +1,119 @@
+package objtree
+
+import (
+	"github.com/godbus/dbus"
+	"testing"
+	"time"
+)
+
+func expectLimitsExceeded(t *testing.T, err error) {
+	t.Helper()
+	switch e := err.(type) {
+	case dbus.Error:
+		err = &e
+	}
+	dbusErr, ok := err.(*dbus.Error)
+	if !ok || dbusErr.Name != fdtLimitsExceeded {
+		t.Fatal("expected LimitsExceeded got:", err)
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	l := NewLimiter(mgr, Limits{Rate: 1, Burst: 2})
+	defer l.Close()
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	next := func(*CallInfo) ([]interface{}, error) { return nil, nil }
+	call := func(sender string) error {
+		_, err := l.Intercept(&CallInfo{
+			Sender:    sender,
+			Interface: "foo",
+			Member:    "Bar",
+		}, next)
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := call(":1.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLimitsExceeded(t, call(":1.1"))
+	if err := call(":1.2"); err != nil {
+		t.Fatal("limits should apply per sender got:", err)
+	}
+	if err := call(""); err != nil {
+		t.Fatal("calls without a sender should not be limited got:", err)
+	}
+	now = now.Add(time.Second)
+	if err := call(":1.1"); err != nil {
+		t.Fatal("the bucket should have refilled got:", err)
+	}
+	expectLimitsExceeded(t, call(":1.1"))
+	l.SetMethodLimits("foo", "Baz", Limits{Rate: 1})
+	_, err := l.Intercept(&CallInfo{
+		Sender:    ":1.2",
+		Interface: "foo",
+		Member:    "Baz",
+	}, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.Intercept(&CallInfo{
+		Sender:    ":1.2",
+		Interface: "foo",
+		Member:    "Baz",
+	}, next)
+	expectLimitsExceeded(t, err)
+	stats := l.Stats()
+	if stats.Allowed != 5 || stats.RejectedRate != 3 ||
+		stats.RejectedInFlight != 0 || stats.InFlight != 0 {
+		t.Fatal("unexpected stats:", stats)
+	}
+	if s := l.SenderStats(":1.1"); s.Allowed != 3 || s.RejectedRate != 2 {
+		t.Fatal("unexpected sender stats:", s)
+	}
+}
+
+func TestLimiterInFlight(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	table := map[string]interface{}{
+		"Block": func() {
+			started <- struct{}{}
+			<-unblock
+		},
+	}
+	obj := mgr.NewObjectFromTable("/foo", table)
+	obj.ImplementsTable("com.example.Test", table)
+	l := NewLimiter(mgr, Limits{MaxInFlight: 1})
+	defer l.Close()
+	mgr.Intercept(l.Intercept)
+	client := newLoopbackClient(t, bus)
+	sender := client.Names()[0]
+	remote := client.Object("com.github.jsouthworth.objtree.Test", "/foo")
+	first := remote.Go("com.example.Test.Block", 0, nil)
+	<-started
+	err := remote.Call("com.example.Test.Block", 0).Err
+	expectLimitsExceeded(t, err)
+	if s := l.SenderStats(sender); s.InFlight != 1 || s.RejectedInFlight != 1 {
+		t.Fatal("unexpected sender stats:", s)
+	}
+	close(unblock)
+	if call := <-first.Done; call.Err != nil {
+		t.Fatal(call.Err)
+	}
+	if err := remote.Call("com.example.Test.Block", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	waitFor(t, func() bool {
+		return l.SenderStats(sender) == LimitStats{}
+	})
+	if stats := l.Stats(); stats.Allowed != 2 || stats.InFlight != 0 {
+		t.Fatal("totals should outlive the sender got:", stats)
+	}
+}
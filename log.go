@@ -0,0 +1,147 @@
+package objtree
+
+import (
+	"context"
+	"fmt"
+	"github.com/godbus/dbus"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+// SetLogger makes the manager log to l. Failed match rule changes,
+// undecodable calls, failing methods and signal handlers, panics in
+// either and, with Timeouts, calls that run past their deadline are
+// logged at warn or error level; every other call and signal delivery
+// is logged at debug level. Passing nil restores the default,
+// slog.Default().
+func (mgr *BusManager) SetLogger(l *slog.Logger) {
+	mgr.state.logger.Store(loggerRef{l})
+}
+
+// loggerRef lets a nil logger be stored in an atomic.Value.
+type loggerRef struct {
+	l *slog.Logger
+}
+
+func (s *mgrState) getLogger() *slog.Logger {
+	if ref, ok := s.logger.Load().(loggerRef); ok && ref.l != nil {
+		return ref.l
+	}
+	return slog.Default()
+}
+
+// logger returns the logger of the manager o belongs to.
+func (o *Object) logger() *slog.Logger {
+	if bus := o.getBus(); bus != nil {
+		return bus.state.getLogger()
+	}
+	return slog.Default()
+}
+
+func (method *Method) logger() *slog.Logger {
+	if method.object == nil {
+		return slog.Default()
+	}
+	return method.object.logger()
+}
+
+// logger returns the logger of the manager the called object belongs
+// to.
+func (call *CallInfo) logger() *slog.Logger {
+	if call.method == nil {
+		return slog.Default()
+	}
+	return call.method.logger()
+}
+
+// callAttrs are the attributes identifying a call to method.
+func (method *Method) callAttrs() []slog.Attr {
+	return []slog.Attr{
+		slog.String("sender", method.sender),
+		slog.String("path", string(method.path())),
+		slog.String("interface", method.iface),
+		slog.String("member", method.name),
+	}
+}
+
+func (method *Method) logDecodeError(err error) {
+	method.logger().LogAttrs(context.Background(), slog.LevelWarn,
+		"objtree: cannot decode arguments",
+		append(method.callAttrs(), slog.Any("error", err))...)
+}
+
+// logCall logs a method call, at warn level if it failed and at debug
+// level otherwise.
+func (method *Method) logCall(ctx context.Context, start time.Time, err error) {
+	level := slog.LevelDebug
+	msg := "objtree: method call"
+	if err != nil {
+		level = slog.LevelWarn
+		msg = "objtree: method call failed"
+	}
+	l := method.logger()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	attrs := append(method.callAttrs(),
+		slog.Duration("duration", time.Since(start)))
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	l.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// logSignal logs the delivery of signal to o, at warn level if the
+// handler failed and at debug level otherwise.
+func (o *Object) logSignal(
+	method *Method,
+	signal *dbus.Signal,
+	start time.Time,
+	err error,
+) {
+	level := slog.LevelDebug
+	msg := "objtree: signal delivered"
+	if err != nil {
+		level = slog.LevelWarn
+		msg = "objtree: signal handler failed"
+	}
+	l := o.logger()
+	ctx := context.Background()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("sender", signal.Sender),
+		slog.String("path", string(signal.Path)),
+		slog.String("receiver", string(o.Path())),
+		slog.String("interface", method.iface),
+		slog.String("member", method.name),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	l.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// callImpl calls the implementation of method, turning a panic into
+// an error after logging it with its stack.
+func (method *Method) callImpl(args []interface{}) (outs []interface{}, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		method.logger().LogAttrs(context.Background(), slog.LevelError,
+			"objtree: panic in handler",
+			append(method.callAttrs(),
+				slog.Any("panic", r),
+				slog.String("stack", string(debug.Stack())))...)
+		outs = nil
+		err = dbus.NewError(fdtFailed, []interface{}{
+			fmt.Sprintf("%s.%s panicked", method.iface, method.name),
+		})
+	}()
+	return method.impl.Call(args...)
+}
@@ -0,0 +1,160 @@
+package objtree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/godbus/dbus"
+	"github.com/jsouthworth/objtree/loopback"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// logBuffer collects the output of a logger shared by several
+// goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *logBuffer) waitFor(t *testing.T, parts ...string) {
+	t.Helper()
+	waitFor(t, func() bool {
+		for _, line := range strings.Split(b.String(), "\n") {
+			if containsAll(line, parts) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func containsAll(s string, parts []string) bool {
+	for _, part := range parts {
+		if !strings.Contains(s, part) {
+			return false
+		}
+	}
+	return true
+}
+
+type logKey struct{}
+
+// ctxHandler adds the value of logKey in the context of each record,
+// as handlers adding trace attributes do.
+type ctxHandler struct {
+	slog.Handler
+}
+
+func (h ctxHandler) Handle(ctx context.Context, r slog.Record) error {
+	if v, ok := ctx.Value(logKey{}).(string); ok {
+		r.AddAttrs(slog.String("ctx", v))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func newLoggingBusManager(
+	t *testing.T,
+) (*loopback.Bus, *BusManager, *logBuffer) {
+	bus, mgr := newLoopbackBusManager(t)
+	buf := &logBuffer{}
+	mgr.SetLogger(slog.New(ctxHandler{slog.NewTextHandler(buf,
+		&slog.HandlerOptions{Level: slog.LevelDebug})}))
+	return bus, mgr, buf
+}
+
+func TestLogCalls(t *testing.T) {
+	bus, mgr, buf := newLoggingBusManager(t)
+	defer bus.Close()
+	table := map[string]interface{}{
+		"Echo":  func(in string) string { return in },
+		"Fail":  func() error { return errors.New("refused") },
+		"Panic": func() { panic("boom") },
+	}
+	obj := mgr.NewObjectFromTable("/foo", table)
+	obj.ImplementsTable("com.example.Test", table)
+	obj.Intercept(func(call *CallInfo, next CallFunc) ([]interface{}, error) {
+		call.Context = context.WithValue(call.Context, logKey{}, "traced")
+		return next(call)
+	})
+	client := newLoopbackClient(t, bus)
+	remote := client.Object("com.github.jsouthworth.objtree.Test", "/foo")
+	if err := remote.Call("com.example.Test.Echo", 0, "hi").Err; err != nil {
+		t.Fatal(err)
+	}
+	buf.waitFor(t, "level=DEBUG", "method call", "path=/foo",
+		"member=Echo", "sender="+client.Names()[0], "ctx=traced")
+	err := remote.Call("com.example.Test.Echo", 0, "hi", "there").Err
+	if err == nil {
+		t.Fatal("expected the call to fail")
+	}
+	buf.waitFor(t, "level=WARN", "cannot decode arguments", "member=Echo")
+	if err := remote.Call("com.example.Test.Fail", 0).Err; err == nil {
+		t.Fatal("expected the call to fail")
+	}
+	buf.waitFor(t, "level=WARN", "method call failed", "member=Fail",
+		"error=refused")
+	err = remote.Call("com.example.Test.Panic", 0).Err
+	if dbusErr, ok := err.(dbus.Error); !ok || dbusErr.Name != fdtFailed {
+		t.Fatal("expected Failed got:", err)
+	}
+	buf.waitFor(t, "level=ERROR", "panic in handler", "panic=boom",
+		"stack=")
+}
+
+func TestLogSignals(t *testing.T) {
+	bus, mgr, buf := newLoggingBusManager(t)
+	defer bus.Close()
+	table := map[string]interface{}{
+		"Changed": func(in string) error {
+			if in == "bad" {
+				return errors.New("rejected")
+			}
+			return nil
+		},
+	}
+	obj := mgr.NewObjectFromTable("/foo", table)
+	obj.ReceivesTable("com.example.Test", table)
+	mgr.DeliverSignal("com.example.Test", "Changed", &dbus.Signal{
+		Path: "/emitter",
+		Body: []interface{}{"good"},
+	})
+	buf.waitFor(t, "level=DEBUG", "signal delivered", "receiver=/foo")
+	mgr.DeliverSignal("com.example.Test", "Changed", &dbus.Signal{
+		Path: "/emitter",
+		Body: []interface{}{"bad"},
+	})
+	buf.waitFor(t, "level=WARN", "signal handler failed", "error=rejected")
+}
+
+func TestLogMatchFailures(t *testing.T) {
+	bus, mgr, buf := newLoggingBusManager(t)
+	defer bus.Close()
+	table := map[string]interface{}{
+		"Changed": func() {},
+	}
+	obj := mgr.NewObjectFromTable("/foo", table)
+	// the stray quote makes the match rule invalid
+	if err := obj.ReceivesTable("com.example.Bad'", table); err == nil {
+		t.Fatal("expected the match rule to be refused")
+	}
+	buf.waitFor(t, "level=WARN", "AddMatch failed", "Bad'")
+	mgr.state.mu.Lock()
+	mgr.state.removeMatch(mgr.conn, "type='signal',member='Unknown'")
+	mgr.state.mu.Unlock()
+	buf.waitFor(t, "level=WARN", "RemoveMatch failed", "member='Unknown'")
+}
@@ -0,0 +1,69 @@
+package loopback
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+var errAuthFailed = errors.New("loopback: authentication failed")
+
+// authenticate runs the server side of the SASL handshake. Every
+// client offering the EXTERNAL mechanism is accepted; the bus trusts
+// the identity of its in-process peers.
+func authenticate(rd *bufio.Reader, w io.Writer, guid string) error {
+	nul, err := rd.ReadByte()
+	if err != nil {
+		return err
+	}
+	if nul != 0 {
+		return errAuthFailed
+	}
+	reply := func(line string) error {
+		_, err := io.WriteString(w, line+"\r\n")
+		return err
+	}
+	waitingForData := false
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		fields := bytes.Fields(line)
+		if len(fields) == 0 {
+			return errAuthFailed
+		}
+		switch string(fields[0]) {
+		case "AUTH":
+			switch {
+			case len(fields) < 2 || string(fields[1]) != "EXTERNAL":
+				err = reply("REJECTED EXTERNAL")
+			case len(fields) == 2:
+				waitingForData = true
+				err = reply("DATA")
+			default:
+				err = reply("OK " + guid)
+			}
+		case "DATA":
+			if !waitingForData {
+				err = reply("ERROR")
+				break
+			}
+			waitingForData = false
+			err = reply("OK " + guid)
+		case "CANCEL", "ERROR":
+			waitingForData = false
+			err = reply("REJECTED EXTERNAL")
+		case "NEGOTIATE_UNIX_FD":
+			err = reply("ERROR")
+		case "BEGIN":
+			return nil
+		default:
+			err = reply("ERROR")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
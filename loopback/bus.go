@@ -0,0 +1,405 @@
+// Package loopback implements an in-process D-Bus message bus.
+//
+// The bus speaks the D-Bus wire protocol over in-memory pipes so that
+// code built on godbus, including objtree's BusManager, can be
+// exercised end to end without a session or system bus. It implements
+// the parts of org.freedesktop.DBus needed for that: Hello, name
+// ownership, match rules, signal routing and connection credentials.
+package loopback
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/godbus/dbus"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	busName      = "org.freedesktop.DBus"
+	busPath      = dbus.ObjectPath("/org/freedesktop/DBus")
+	busInterface = "org.freedesktop.DBus"
+	peerIface    = "org.freedesktop.DBus.Peer"
+)
+
+// Flags and replies of org.freedesktop.DBus.RequestName.
+const (
+	nameFlagAllowReplacement = 0x1
+	nameFlagReplaceExisting  = 0x2
+
+	requestNamePrimaryOwner = 1
+	requestNameExists       = 3
+	requestNameAlreadyOwner = 4
+
+	releaseNameReleased    = 1
+	releaseNameNonExistent = 2
+	releaseNameNotOwner    = 3
+)
+
+var ErrClosed = errors.New("loopback: bus closed")
+
+// Bus is an in-process message bus. The zero value is not usable;
+// create one with New.
+type Bus struct {
+	guid   string
+	serial uint32
+
+	mu     sync.Mutex
+	closed bool
+	nextID uint64
+	conns  map[string]*conn
+	names  map[string]*nameOwner
+}
+
+type nameOwner struct {
+	conn             *conn
+	allowReplacement bool
+}
+
+func New() *Bus {
+	var id [16]byte
+	rand.Read(id[:])
+	return &Bus{
+		guid:  hex.EncodeToString(id[:]),
+		conns: make(map[string]*conn),
+		names: make(map[string]*nameOwner),
+	}
+}
+
+// Dial returns a private connection to the bus using godbus' default
+// handlers. As with dbus.SessionBusPrivate the caller must call Auth
+// and Hello before using the connection.
+func (b *Bus) Dial() (*dbus.Conn, error) {
+	return b.DialHandler(dbus.NewDefaultHandler(),
+		dbus.NewDefaultSignalHandler())
+}
+
+// DialHandler returns a private connection to the bus using the
+// supplied handlers. Its signature matches the bus functions accepted
+// by objtree.NewBusManager.
+func (b *Bus) DialHandler(
+	handler dbus.Handler,
+	signalHandler dbus.SignalHandler,
+) (*dbus.Conn, error) {
+	client, server := net.Pipe()
+	c := &conn{
+		bus:   b,
+		rw:    server,
+		out:   make(chan []byte, 128),
+		done:  make(chan struct{}),
+		creds: processCredentials(),
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		client.Close()
+		server.Close()
+		return nil, ErrClosed
+	}
+	b.nextID++
+	c.id = b.nextID
+	b.conns[c.key()] = c
+	b.mu.Unlock()
+	go c.serve()
+	return dbus.NewConnHandler(client, handler, signalHandler)
+}
+
+// Close disconnects every connection attached to the bus.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	conns := make([]*conn, 0, len(b.conns))
+	for _, c := range b.conns {
+		conns = append(conns, c)
+	}
+	b.mu.Unlock()
+	for _, c := range conns {
+		c.close()
+	}
+	return nil
+}
+
+func (b *Bus) nextSerial() uint32 {
+	for {
+		if s := atomic.AddUint32(&b.serial, 1); s != 0 {
+			return s
+		}
+	}
+}
+
+// owner returns the unique name of the connection owning name, or
+// the empty string. The caller must hold b.mu.
+func (b *Bus) owner(name string) string {
+	if name == busName {
+		return busName
+	}
+	if strings.HasPrefix(name, ":") {
+		if _, ok := b.conns[name]; ok {
+			return name
+		}
+		return ""
+	}
+	if o, ok := b.names[name]; ok {
+		return o.conn.uniqueName
+	}
+	return ""
+}
+
+// lookup returns the connection addressed by name, which may be
+// either a unique or a well-known name. The caller must hold b.mu.
+func (b *Bus) lookup(name string) (*conn, bool) {
+	if strings.HasPrefix(name, ":") {
+		c, ok := b.conns[name]
+		return c, ok && c.uniqueName != ""
+	}
+	o, ok := b.names[name]
+	if !ok {
+		return nil, false
+	}
+	return o.conn, true
+}
+
+func (b *Bus) route(from *conn, msg *message) {
+	dest := headerString(msg.Message, dbus.FieldDestination)
+	switch {
+	case msg.Type == dbus.TypeMethodCall && (dest == busName || dest == ""):
+		b.handleBusCall(from, msg)
+	case msg.Type == dbus.TypeSignal && dest == "":
+		b.broadcast(msg.Message, from.uniqueName, msg.encode)
+	default:
+		b.mu.Lock()
+		to, ok := b.lookup(dest)
+		b.mu.Unlock()
+		if !ok {
+			if msg.Type == dbus.TypeMethodCall &&
+				msg.Flags&dbus.FlagNoReplyExpected == 0 {
+				b.sendError(from, msg, "org.freedesktop.DBus.Error.ServiceUnknown",
+					"The name "+dest+" was not provided by any .service files")
+			}
+			return
+		}
+		data, err := msg.encode()
+		if err != nil {
+			return
+		}
+		to.send(data)
+	}
+}
+
+// broadcast delivers a signal to every connection with a matching
+// rule. encode is only invoked if there is at least one recipient.
+func (b *Bus) broadcast(
+	msg *dbus.Message,
+	sender string,
+	encode func() ([]byte, error),
+) {
+	b.mu.Lock()
+	var recipients []*conn
+	for _, c := range b.conns {
+		for _, rule := range c.rules {
+			if rule.matches(msg, sender, b.owner) {
+				recipients = append(recipients, c)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	if len(recipients) == 0 {
+		return
+	}
+	data, err := encode()
+	if err != nil {
+		return
+	}
+	for _, c := range recipients {
+		c.send(data)
+	}
+}
+
+// emit sends a signal originating from the bus itself. If dest is
+// empty the signal is broadcast according to match rules.
+func (b *Bus) emit(dest *conn, member string, body ...interface{}) {
+	msg := &dbus.Message{
+		Type: dbus.TypeSignal,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldPath:      dbus.MakeVariant(busPath),
+			dbus.FieldInterface: dbus.MakeVariant(busInterface),
+			dbus.FieldMember:    dbus.MakeVariant(member),
+			dbus.FieldSender:    dbus.MakeVariant(busName),
+		},
+		Body: body,
+	}
+	if len(body) > 0 {
+		msg.Headers[dbus.FieldSignature] = dbus.MakeVariant(
+			dbus.SignatureOf(body...))
+	}
+	encode := func() ([]byte, error) {
+		return encodeMessage(msg, b.nextSerial())
+	}
+	if dest == nil {
+		b.broadcast(msg, busName, encode)
+		return
+	}
+	msg.Headers[dbus.FieldDestination] = dbus.MakeVariant(dest.uniqueName)
+	data, err := encode()
+	if err != nil {
+		return
+	}
+	dest.send(data)
+}
+
+func (b *Bus) sendReply(to *conn, call *message, body ...interface{}) {
+	if call.Flags&dbus.FlagNoReplyExpected != 0 {
+		return
+	}
+	msg := &dbus.Message{
+		Type: dbus.TypeMethodReply,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldReplySerial: dbus.MakeVariant(call.serial),
+			dbus.FieldSender:      dbus.MakeVariant(busName),
+		},
+		Body: body,
+	}
+	if to.uniqueName != "" {
+		msg.Headers[dbus.FieldDestination] = dbus.MakeVariant(to.uniqueName)
+	}
+	if len(body) > 0 {
+		msg.Headers[dbus.FieldSignature] = dbus.MakeVariant(
+			dbus.SignatureOf(body...))
+	}
+	data, err := encodeMessage(msg, b.nextSerial())
+	if err != nil {
+		return
+	}
+	to.send(data)
+}
+
+func (b *Bus) sendError(to *conn, call *message, name, text string) {
+	if call.Flags&dbus.FlagNoReplyExpected != 0 {
+		return
+	}
+	msg := &dbus.Message{
+		Type: dbus.TypeError,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldReplySerial: dbus.MakeVariant(call.serial),
+			dbus.FieldSender:      dbus.MakeVariant(busName),
+			dbus.FieldErrorName:   dbus.MakeVariant(name),
+			dbus.FieldSignature:   dbus.MakeVariant(dbus.SignatureOf(text)),
+		},
+		Body: []interface{}{text},
+	}
+	if to.uniqueName != "" {
+		msg.Headers[dbus.FieldDestination] = dbus.MakeVariant(to.uniqueName)
+	}
+	data, err := encodeMessage(msg, b.nextSerial())
+	if err != nil {
+		return
+	}
+	to.send(data)
+}
+
+func (b *Bus) disconnect(c *conn) {
+	b.mu.Lock()
+	delete(b.conns, c.key())
+	var released []string
+	for name, o := range b.names {
+		if o.conn == c {
+			delete(b.names, name)
+			released = append(released, name)
+		}
+	}
+	b.mu.Unlock()
+	if c.uniqueName == "" {
+		return
+	}
+	for _, name := range released {
+		b.emit(nil, "NameOwnerChanged", name, c.uniqueName, "")
+	}
+	b.emit(nil, "NameOwnerChanged", c.uniqueName, c.uniqueName, "")
+}
+
+type conn struct {
+	bus  *Bus
+	id   uint64
+	rw   net.Conn
+	out  chan []byte
+	done chan struct{}
+	once sync.Once
+
+	// Guarded by bus.mu
+	uniqueName string
+	rules      []*matchRule
+	creds      Credentials
+}
+
+// key identifies the connection in Bus.conns; it is the unique name
+// once Hello has been called.
+func (c *conn) key() string {
+	return ":1." + strconv.FormatUint(c.id, 10)
+}
+
+func (c *conn) serve() {
+	defer c.bus.disconnect(c)
+	defer c.close()
+	rd := bufio.NewReader(c.rw)
+	if err := authenticate(rd, c.rw, c.bus.guid); err != nil {
+		return
+	}
+	go c.writer()
+	for {
+		msg, err := readMessage(rd)
+		if err != nil {
+			return
+		}
+		if c.uniqueName == "" && !isHello(msg) {
+			// The first message on a bus connection must be Hello.
+			return
+		}
+		if c.uniqueName != "" {
+			msg.Headers[dbus.FieldSender] = dbus.MakeVariant(c.uniqueName)
+		}
+		c.bus.route(c, msg)
+	}
+}
+
+func (c *conn) writer() {
+	for {
+		select {
+		case data := <-c.out:
+			if _, err := c.rw.Write(data); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *conn) send(data []byte) {
+	select {
+	case c.out <- data:
+	case <-c.done:
+	}
+}
+
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.rw.Close()
+	})
+}
+
+func isHello(msg *message) bool {
+	return msg.Type == dbus.TypeMethodCall &&
+		headerString(msg.Message, dbus.FieldMember) == "Hello"
+}
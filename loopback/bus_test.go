@@ -0,0 +1,337 @@
+package loopback
+
+import (
+	"github.com/godbus/dbus"
+	"os"
+	"testing"
+	"time"
+)
+
+func connect(t *testing.T, bus *Bus) *dbus.Conn {
+	conn, err := bus.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// waitForSignal skips signals sent by the bus itself, such as
+// NameAcquired, that race with the test's own traffic.
+func waitForSignal(t *testing.T, ch chan *dbus.Signal, name string) *dbus.Signal {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case sig := <-ch:
+			if sig.Name == name {
+				return sig
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for", name)
+		}
+	}
+}
+
+func expectNoSignal(t *testing.T, ch chan *dbus.Signal, name string) {
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case sig := <-ch:
+			if sig.Name == name {
+				t.Fatal("unexpected signal:", sig)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestHello(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	conn := connect(t, bus)
+	names := conn.Names()
+	if len(names) == 0 || names[0] != ":1.1" {
+		t.Fatal("unexpected unique name:", names)
+	}
+	conn2 := connect(t, bus)
+	if conn2.Names()[0] != ":1.2" {
+		t.Fatal("unexpected unique name:", conn2.Names())
+	}
+}
+
+func TestRequestName(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	conn := connect(t, bus)
+	reply, err := conn.RequestName("com.github.jsouthworth.objtree.Test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatal("expected primary owner got:", reply)
+	}
+	reply, err = conn.RequestName("com.github.jsouthworth.objtree.Test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != dbus.RequestNameReplyAlreadyOwner {
+		t.Fatal("expected already owner got:", reply)
+	}
+	conn2 := connect(t, bus)
+	reply, err = conn2.RequestName("com.github.jsouthworth.objtree.Test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != dbus.RequestNameReplyExists {
+		t.Fatal("expected exists got:", reply)
+	}
+	var owner string
+	err = conn2.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0,
+		"com.github.jsouthworth.objtree.Test").Store(&owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != conn.Names()[0] {
+		t.Fatal("expected:", conn.Names()[0], "got:", owner)
+	}
+}
+
+func TestRequestNameInvalid(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	conn := connect(t, bus)
+	_, err := conn.RequestName("foo", 0)
+	if err == nil {
+		t.Fatal("expected invalid name to be rejected")
+	}
+}
+
+func TestReleaseNameOnDisconnect(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	conn := connect(t, bus)
+	_, err := conn.RequestName("com.github.jsouthworth.objtree.Test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher := connect(t, bus)
+	ch := make(chan *dbus.Signal, 10)
+	watcher.Signal(ch)
+	err = watcher.BusObject().Call("org.freedesktop.DBus.AddMatch", 0,
+		"type='signal',member='NameOwnerChanged',"+
+			"arg0='com.github.jsouthworth.objtree.Test'").Err
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := conn.Names()[0]
+	conn.Close()
+	sig := waitForSignal(t, ch, "org.freedesktop.DBus.NameOwnerChanged")
+	if sig.Body[1].(string) != owner || sig.Body[2].(string) != "" {
+		t.Fatal("unexpected NameOwnerChanged:", sig.Body)
+	}
+	var hasOwner bool
+	err = watcher.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0,
+		"com.github.jsouthworth.objtree.Test").Store(&hasOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasOwner {
+		t.Fatal("name should have been released")
+	}
+}
+
+func TestMethodCall(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	server := connect(t, bus)
+	_, err := server.RequestName("com.github.jsouthworth.objtree.Test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Export(testServer{}, "/foo", "com.github.jsouthworth.objtree.Test")
+	client := connect(t, bus)
+	var out string
+	err = client.Object("com.github.jsouthworth.objtree.Test", "/foo").
+		Call("com.github.jsouthworth.objtree.Test.Echo", 0,
+			"hello, world").Store(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "hello, world" {
+		t.Fatal("expected: hello, world got:", out)
+	}
+}
+
+func TestMethodCallUnknownService(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	client := connect(t, bus)
+	err := client.Object("com.github.jsouthworth.objtree.Missing", "/foo").
+		Call("com.github.jsouthworth.objtree.Test.Echo", 0, "").Err
+	dbusErr, ok := err.(dbus.Error)
+	if !ok {
+		t.Fatal("expected dbus.Error got:", err)
+	}
+	if dbusErr.Name != "org.freedesktop.DBus.Error.ServiceUnknown" {
+		t.Fatal("unexpected error:", dbusErr.Name)
+	}
+}
+
+func TestConnectionCredentials(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	conn := connect(t, bus)
+	name := conn.Names()[0]
+	var uid uint32
+	err := conn.BusObject().Call("org.freedesktop.DBus.GetConnectionUnixUser",
+		0, name).Store(&uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != uint32(os.Getuid()) {
+		t.Fatal("expected the uid of the process got:", uid)
+	}
+	expected := Credentials{UID: 1000, GIDs: []uint32{100, 10}, PID: 42}
+	if err := bus.SetCredentials(name, expected); err != nil {
+		t.Fatal(err)
+	}
+	var props map[string]dbus.Variant
+	err = conn.BusObject().Call("org.freedesktop.DBus.GetConnectionCredentials",
+		0, name).Store(&props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if props["UnixUserID"].Value().(uint32) != 1000 ||
+		props["ProcessID"].Value().(uint32) != 42 ||
+		len(props["UnixGroupIDs"].Value().([]uint32)) != 2 {
+		t.Fatal("unexpected credentials:", props)
+	}
+	if err := bus.SetCredentials(":1.99", expected); err != ErrNoSuchName {
+		t.Fatal("expected ErrNoSuchName got:", err)
+	}
+}
+
+type testServer struct{}
+
+func (testServer) Echo(in string) (string, *dbus.Error) {
+	return in, nil
+}
+
+func TestSignalRouting(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	emitter := connect(t, bus)
+	listener := connect(t, bus)
+	other := connect(t, bus)
+	ch := make(chan *dbus.Signal, 10)
+	listener.Signal(ch)
+	otherCh := make(chan *dbus.Signal, 10)
+	other.Signal(otherCh)
+	err := listener.BusObject().Call("org.freedesktop.DBus.AddMatch", 0,
+		"type='signal',interface='foo.bar',member='Baz'").Err
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.BusObject().Call("org.freedesktop.DBus.AddMatch", 0,
+		"type='signal',interface='foo.bar',member='Quux'").Err
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = emitter.Emit("/foo", "foo.bar.Baz", "hello, world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := waitForSignal(t, ch, "foo.bar.Baz")
+	if sig.Path != "/foo" || sig.Sender != emitter.Names()[0] {
+		t.Fatal("unexpected signal:", sig)
+	}
+	if sig.Body[0].(string) != "hello, world" {
+		t.Fatal("unexpected body:", sig.Body)
+	}
+	expectNoSignal(t, otherCh, "foo.bar.Quux")
+}
+
+func TestRemoveMatch(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	emitter := connect(t, bus)
+	listener := connect(t, bus)
+	ch := make(chan *dbus.Signal, 10)
+	listener.Signal(ch)
+	const rule = "type='signal',interface='foo.bar',member='Baz'"
+	err := listener.BusObject().Call("org.freedesktop.DBus.AddMatch", 0,
+		rule).Err
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = listener.BusObject().Call("org.freedesktop.DBus.RemoveMatch", 0,
+		rule).Err
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = listener.BusObject().Call("org.freedesktop.DBus.RemoveMatch", 0,
+		rule).Err
+	if err == nil {
+		t.Fatal("expected removing a missing rule to fail")
+	}
+	err = emitter.Emit("/foo", "foo.bar.Baz", "hello, world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNoSignal(t, ch, "foo.bar.Baz")
+}
+
+func TestAddMatchInvalid(t *testing.T) {
+	bus := New()
+	defer bus.Close()
+	conn := connect(t, bus)
+	err := conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0,
+		"type='bogus'").Err
+	if err == nil {
+		t.Fatal("expected invalid match rule to be rejected")
+	}
+}
+
+func TestParseMatchRule(t *testing.T) {
+	m, err := parseMatchRule(
+		"type='signal',sender='org.foo',path_namespace='/a'," +
+			"arg0='it'\\''s'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.typ != "signal" || m.sender != "org.foo" ||
+		m.pathNamespace != "/a" || m.args[0] != "it's" {
+		t.Fatalf("unexpected rule: %+v", m)
+	}
+	for _, rule := range []string{
+		"type='signal",
+		"bogus='1'",
+		"arg64='x'",
+		"path='/a',path_namespace='/a'",
+	} {
+		if _, err := parseMatchRule(rule); err == nil {
+			t.Fatal("expected error for rule:", rule)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	bus := New()
+	conn := connect(t, bus)
+	bus.Close()
+	err := conn.BusObject().Call("org.freedesktop.DBus.GetId", 0).Err
+	if err == nil {
+		t.Fatal("expected call on closed bus to fail")
+	}
+	if _, err := bus.Dial(); err != ErrClosed {
+		t.Fatal("expected ErrClosed got:", err)
+	}
+}
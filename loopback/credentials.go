@@ -0,0 +1,75 @@
+package loopback
+
+import (
+	"errors"
+	"github.com/godbus/dbus"
+	"os"
+)
+
+var ErrNoSuchName = errors.New("loopback: name has no owner")
+
+// Credentials are what the bus reports about the process behind a
+// connection. Every connection starts out with those of the current
+// process.
+type Credentials struct {
+	UID  uint32
+	GIDs []uint32
+	PID  uint32
+}
+
+func processCredentials() Credentials {
+	creds := Credentials{
+		UID:  uint32(os.Getuid()),
+		GIDs: []uint32{uint32(os.Getgid())},
+		PID:  uint32(os.Getpid()),
+	}
+	groups, _ := os.Getgroups()
+	for _, gid := range groups {
+		if uint32(gid) != creds.GIDs[0] {
+			creds.GIDs = append(creds.GIDs, uint32(gid))
+		}
+	}
+	return creds
+}
+
+// SetCredentials changes the credentials reported for the connection
+// owning name, so that tests can pose as other users or processes.
+func (b *Bus) SetCredentials(name string, creds Credentials) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.lookup(name)
+	if !ok {
+		return ErrNoSuchName
+	}
+	c.creds = creds
+	return nil
+}
+
+// credentials implements the GetConnection* methods of the bus
+// driver.
+func (b *Bus) credentials(from *conn, call *message, member, name string) {
+	b.mu.Lock()
+	c, ok := b.lookup(name)
+	var creds Credentials
+	if ok {
+		creds = c.creds
+	}
+	b.mu.Unlock()
+	if !ok {
+		b.sendError(from, call, errNameHasNoOwner,
+			"Could not get credentials of name '"+name+"': no such name")
+		return
+	}
+	switch member {
+	case "GetConnectionUnixUser":
+		b.sendReply(from, call, creds.UID)
+	case "GetConnectionUnixProcessID":
+		b.sendReply(from, call, creds.PID)
+	case "GetConnectionCredentials":
+		b.sendReply(from, call, map[string]dbus.Variant{
+			"UnixUserID":   dbus.MakeVariant(creds.UID),
+			"UnixGroupIDs": dbus.MakeVariant(creds.GIDs),
+			"ProcessID":    dbus.MakeVariant(creds.PID),
+		})
+	}
+}
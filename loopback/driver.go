@@ -0,0 +1,268 @@
+package loopback
+
+import (
+	"github.com/godbus/dbus"
+	"sort"
+	"strings"
+)
+
+const (
+	errUnknownMethod     = "org.freedesktop.DBus.Error.UnknownMethod"
+	errInvalidArgs       = "org.freedesktop.DBus.Error.InvalidArgs"
+	errNameHasNoOwner    = "org.freedesktop.DBus.Error.NameHasNoOwner"
+	errMatchRuleInvalid  = "org.freedesktop.DBus.Error.MatchRuleInvalid"
+	errMatchRuleNotFound = "org.freedesktop.DBus.Error.MatchRuleNotFound"
+	errFailed            = "org.freedesktop.DBus.Error.Failed"
+)
+
+// handleBusCall implements the methods of the bus driver,
+// org.freedesktop.DBus.
+func (b *Bus) handleBusCall(from *conn, call *message) {
+	iface := headerString(call.Message, dbus.FieldInterface)
+	member := headerString(call.Message, dbus.FieldMember)
+	if iface == peerIface {
+		switch member {
+		case "Ping":
+			b.sendReply(from, call)
+		case "GetMachineId":
+			b.sendReply(from, call, b.guid)
+		default:
+			b.sendError(from, call, errUnknownMethod,
+				"Unknown method "+member)
+		}
+		return
+	}
+	if iface != "" && iface != busInterface {
+		b.sendError(from, call, errUnknownMethod,
+			"Unknown interface "+iface)
+		return
+	}
+	args := call.Body
+	switch member {
+	case "Hello":
+		b.hello(from, call)
+	case "RequestName":
+		var name string
+		var flags uint32
+		if dbus.Store(args, &name, &flags) != nil {
+			b.sendError(from, call, errInvalidArgs, "Expected (su)")
+			return
+		}
+		b.requestName(from, call, name, flags)
+	case "ReleaseName":
+		var name string
+		if dbus.Store(args, &name) != nil {
+			b.sendError(from, call, errInvalidArgs, "Expected (s)")
+			return
+		}
+		b.releaseName(from, call, name)
+	case "AddMatch":
+		var rule string
+		if dbus.Store(args, &rule) != nil {
+			b.sendError(from, call, errInvalidArgs, "Expected (s)")
+			return
+		}
+		b.addMatch(from, call, rule)
+	case "RemoveMatch":
+		var rule string
+		if dbus.Store(args, &rule) != nil {
+			b.sendError(from, call, errInvalidArgs, "Expected (s)")
+			return
+		}
+		b.removeMatch(from, call, rule)
+	case "GetNameOwner":
+		var name string
+		if dbus.Store(args, &name) != nil {
+			b.sendError(from, call, errInvalidArgs, "Expected (s)")
+			return
+		}
+		b.mu.Lock()
+		owner := b.owner(name)
+		b.mu.Unlock()
+		if owner == "" {
+			b.sendError(from, call, errNameHasNoOwner,
+				"Could not get owner of name '"+name+"': no such name")
+			return
+		}
+		b.sendReply(from, call, owner)
+	case "NameHasOwner":
+		var name string
+		if dbus.Store(args, &name) != nil {
+			b.sendError(from, call, errInvalidArgs, "Expected (s)")
+			return
+		}
+		b.mu.Lock()
+		owner := b.owner(name)
+		b.mu.Unlock()
+		b.sendReply(from, call, owner != "")
+	case "GetConnectionUnixUser", "GetConnectionUnixProcessID",
+		"GetConnectionCredentials":
+		var name string
+		if dbus.Store(args, &name) != nil {
+			b.sendError(from, call, errInvalidArgs, "Expected (s)")
+			return
+		}
+		b.credentials(from, call, member, name)
+	case "ListNames":
+		b.sendReply(from, call, b.listNames())
+	case "GetId":
+		b.sendReply(from, call, b.guid)
+	default:
+		b.sendError(from, call, errUnknownMethod,
+			"Unknown method "+member)
+	}
+}
+
+func (b *Bus) hello(from *conn, call *message) {
+	if from.uniqueName != "" {
+		b.sendError(from, call, errFailed, "Already handled an Hello message")
+		return
+	}
+	b.mu.Lock()
+	from.uniqueName = from.key()
+	b.mu.Unlock()
+	b.sendReply(from, call, from.uniqueName)
+	b.emit(nil, "NameOwnerChanged", from.uniqueName, "", from.uniqueName)
+	b.emit(from, "NameAcquired", from.uniqueName)
+}
+
+func (b *Bus) requestName(from *conn, call *message, name string, flags uint32) {
+	if !isValidWellKnownName(name) {
+		b.sendError(from, call, errInvalidArgs,
+			"Requested bus name \""+name+"\" is not valid")
+		return
+	}
+	b.mu.Lock()
+	current, owned := b.names[name]
+	switch {
+	case owned && current.conn == from:
+		current.allowReplacement = flags&nameFlagAllowReplacement != 0
+		b.mu.Unlock()
+		b.sendReply(from, call, uint32(requestNameAlreadyOwner))
+		return
+	case owned && !(current.allowReplacement &&
+		flags&nameFlagReplaceExisting != 0):
+		b.mu.Unlock()
+		b.sendReply(from, call, uint32(requestNameExists))
+		return
+	}
+	b.names[name] = &nameOwner{
+		conn:             from,
+		allowReplacement: flags&nameFlagAllowReplacement != 0,
+	}
+	b.mu.Unlock()
+	// NameAcquired must reach the client before the reply so that
+	// godbus accepts messages addressed to the new name as soon as
+	// RequestName returns.
+	oldOwner := ""
+	if owned {
+		oldOwner = current.conn.uniqueName
+		b.emit(current.conn, "NameLost", name)
+	}
+	b.emit(nil, "NameOwnerChanged", name, oldOwner, from.uniqueName)
+	b.emit(from, "NameAcquired", name)
+	b.sendReply(from, call, uint32(requestNamePrimaryOwner))
+}
+
+func (b *Bus) releaseName(from *conn, call *message, name string) {
+	if !isValidWellKnownName(name) {
+		b.sendError(from, call, errInvalidArgs,
+			"Given bus name \""+name+"\" is not valid")
+		return
+	}
+	b.mu.Lock()
+	current, owned := b.names[name]
+	switch {
+	case !owned:
+		b.mu.Unlock()
+		b.sendReply(from, call, uint32(releaseNameNonExistent))
+		return
+	case current.conn != from:
+		b.mu.Unlock()
+		b.sendReply(from, call, uint32(releaseNameNotOwner))
+		return
+	}
+	delete(b.names, name)
+	b.mu.Unlock()
+	b.emit(nil, "NameOwnerChanged", name, from.uniqueName, "")
+	b.emit(from, "NameLost", name)
+	b.sendReply(from, call, uint32(releaseNameReleased))
+}
+
+func (b *Bus) addMatch(from *conn, call *message, rule string) {
+	m, err := parseMatchRule(rule)
+	if err != nil {
+		b.sendError(from, call, errMatchRuleInvalid,
+			"Invalid match rule \""+rule+"\"")
+		return
+	}
+	b.mu.Lock()
+	from.rules = append(from.rules, m)
+	b.mu.Unlock()
+	b.sendReply(from, call)
+}
+
+func (b *Bus) removeMatch(from *conn, call *message, rule string) {
+	if _, err := parseMatchRule(rule); err != nil {
+		b.sendError(from, call, errMatchRuleInvalid,
+			"Invalid match rule \""+rule+"\"")
+		return
+	}
+	b.mu.Lock()
+	for i, m := range from.rules {
+		if m.rule == rule {
+			from.rules = append(from.rules[:i:i], from.rules[i+1:]...)
+			b.mu.Unlock()
+			b.sendReply(from, call)
+			return
+		}
+	}
+	b.mu.Unlock()
+	b.sendError(from, call, errMatchRuleNotFound,
+		"The given match rule wasn't found and can't be removed")
+}
+
+func (b *Bus) listNames() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := []string{busName}
+	for name, c := range b.conns {
+		if c.uniqueName != "" {
+			out = append(out, name)
+		}
+	}
+	for name := range b.names {
+		out = append(out, name)
+	}
+	sort.Strings(out[1:])
+	return out
+}
+
+func isValidWellKnownName(name string) bool {
+	if len(name) == 0 || len(name) > 255 || name[0] == ':' ||
+		name == busName {
+		return false
+	}
+	elems := strings.Split(name, ".")
+	if len(elems) < 2 {
+		return false
+	}
+	for _, elem := range elems {
+		if len(elem) == 0 || (elem[0] >= '0' && elem[0] <= '9') {
+			return false
+		}
+		for _, c := range elem {
+			if !isNameChar(c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isNameChar(c rune) bool {
+	return c == '_' || c == '-' ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
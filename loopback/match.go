@@ -0,0 +1,179 @@
+package loopback
+
+import (
+	"errors"
+	"github.com/godbus/dbus"
+	"strconv"
+	"strings"
+)
+
+var errInvalidMatchRule = errors.New("loopback: invalid match rule")
+
+type matchRule struct {
+	rule          string
+	typ           string
+	sender        string
+	iface         string
+	member        string
+	path          string
+	pathNamespace string
+	destination   string
+	args          map[int]string
+}
+
+// parseMatchRule parses the subset of the match rule grammar the bus
+// understands: type, sender, interface, member, path, path_namespace,
+// destination and argN.
+func parseMatchRule(rule string) (*matchRule, error) {
+	m := &matchRule{rule: rule}
+	pairs, err := splitMatchRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range pairs {
+		switch key {
+		case "type":
+			switch value {
+			case "signal", "method_call", "method_return", "error":
+			default:
+				return nil, errInvalidMatchRule
+			}
+			m.typ = value
+		case "sender":
+			m.sender = value
+		case "interface":
+			m.iface = value
+		case "member":
+			m.member = value
+		case "path":
+			m.path = value
+		case "path_namespace":
+			m.pathNamespace = value
+		case "destination":
+			m.destination = value
+		default:
+			if !strings.HasPrefix(key, "arg") {
+				return nil, errInvalidMatchRule
+			}
+			n, err := strconv.Atoi(key[len("arg"):])
+			if err != nil || n < 0 || n > 63 {
+				return nil, errInvalidMatchRule
+			}
+			if m.args == nil {
+				m.args = make(map[int]string)
+			}
+			m.args[n] = value
+		}
+	}
+	if m.path != "" && m.pathNamespace != "" {
+		return nil, errInvalidMatchRule
+	}
+	return m, nil
+}
+
+func splitMatchRule(rule string) (map[string]string, error) {
+	out := make(map[string]string)
+	for len(rule) > 0 {
+		eq := strings.IndexByte(rule, '=')
+		if eq <= 0 {
+			return nil, errInvalidMatchRule
+		}
+		key := strings.TrimSpace(rule[:eq])
+		rule = rule[eq+1:]
+		var value []byte
+		quoted := false
+		i := 0
+	value:
+		for ; i < len(rule); i++ {
+			c := rule[i]
+			switch {
+			case c == '\'':
+				quoted = !quoted
+			case !quoted && c == '\\' && i+1 < len(rule) &&
+				rule[i+1] == '\'':
+				value = append(value, '\'')
+				i++
+			case !quoted && c == ',':
+				break value
+			default:
+				value = append(value, c)
+			}
+		}
+		if quoted {
+			return nil, errInvalidMatchRule
+		}
+		if _, dup := out[key]; dup {
+			return nil, errInvalidMatchRule
+		}
+		out[key] = string(value)
+		if i < len(rule) {
+			i++
+		}
+		rule = rule[i:]
+	}
+	return out, nil
+}
+
+func messageTypeName(t dbus.Type) string {
+	switch t {
+	case dbus.TypeMethodCall:
+		return "method_call"
+	case dbus.TypeMethodReply:
+		return "method_return"
+	case dbus.TypeError:
+		return "error"
+	case dbus.TypeSignal:
+		return "signal"
+	}
+	return ""
+}
+
+// matches reports whether msg, sent by the connection owning the
+// unique name sender, is selected by the rule. owner resolves
+// well-known names to their current unique owner.
+func (m *matchRule) matches(
+	msg *dbus.Message,
+	sender string,
+	owner func(string) string,
+) bool {
+	if m.typ != "" && m.typ != messageTypeName(msg.Type) {
+		return false
+	}
+	if m.sender != "" && m.sender != sender && owner(m.sender) != sender {
+		return false
+	}
+	if m.iface != "" && m.iface != headerString(msg, dbus.FieldInterface) {
+		return false
+	}
+	if m.member != "" && m.member != headerString(msg, dbus.FieldMember) {
+		return false
+	}
+	path := string(headerPath(msg))
+	if m.path != "" && m.path != path {
+		return false
+	}
+	if m.pathNamespace != "" && !inPathNamespace(path, m.pathNamespace) {
+		return false
+	}
+	if m.destination != "" &&
+		m.destination != headerString(msg, dbus.FieldDestination) {
+		return false
+	}
+	for n, want := range m.args {
+		if n >= len(msg.Body) {
+			return false
+		}
+		got, ok := msg.Body[n].(string)
+		if !ok || got != want {
+			return false
+		}
+	}
+	return true
+}
+
+func inPathNamespace(path, namespace string) bool {
+	if namespace == "/" || path == namespace {
+		return true
+	}
+	return strings.HasPrefix(path, namespace+"/")
+}
@@ -0,0 +1,44 @@
+package objtree
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+// Locations searched for the machine id, in order.
+var machineIdFiles = []string{
+	"/etc/machine-id",
+	"/var/lib/dbus/machine-id",
+}
+
+var errNoMachineId = errors.New("Unable to read machine id")
+
+func readMachineId() (string, error) {
+	for _, file := range machineIdFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		id := strings.TrimSpace(string(data))
+		if isValidMachineId(id) {
+			return id, nil
+		}
+	}
+	return "", errNoMachineId
+}
+
+func isValidMachineId(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'f':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package objtree
+
+import (
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus"
+)
+
+const fdtMatchRuleNotFound = fdtDBusName + ".Error.MatchRuleNotFound"
+
+// addMatch adds rule to the bus and records it as added. The caller
+// must hold s.mu.
+func (s *mgrState) addMatch(conn *dbus.Conn, rule string) error {
+	err := conn.BusObject().Call(fdtAddMatch, 0, rule).Err
+	if err != nil {
+		s.getLogger().Warn("objtree: AddMatch failed",
+			"rule", rule, "error", err)
+		return err
+	}
+	s.matches[rule] = struct{}{}
+	return nil
+}
+
+// removeMatch removes rule from the bus. The rule stays recorded as
+// added if the bus may still have it. The caller must hold s.mu.
+func (s *mgrState) removeMatch(conn *dbus.Conn, rule string) error {
+	err := conn.BusObject().Call(fdtRemoveMatch, 0, rule).Err
+	if err != nil {
+		s.getLogger().Warn("objtree: RemoveMatch failed",
+			"rule", rule, "error", err)
+	}
+	if err == nil || errorName(err) == fdtMatchRuleNotFound {
+		delete(s.matches, rule)
+	}
+	return err
+}
+
+// wantedMatches returns the match rules the manager needs: those of
+// its listeners, of the owners of its objects and, while anything
+// tracks names, NameOwnerChanged.
+func (s *mgrState) wantedMatches() map[string]struct{} {
+	wanted := make(map[string]struct{})
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	for key := range s.sigref {
+		wanted[key.rule()] = struct{}{}
+	}
+	for name := range s.owners {
+		wanted[ownerMatchRule(name)] = struct{}{}
+	}
+	if len(s.trackers) > 0 {
+		wanted[nameOwnerChangedRule] = struct{}{}
+	}
+	return wanted
+}
+
+// syncMatch adds rule to the bus or removes it from the bus depending
+// on whether it is in wanted. Rules are compared with the references
+// held rather than changed as references come and go, so that
+// concurrent changes settle on the rules still needed. The caller must
+// hold s.mu.
+func (s *mgrState) syncMatch(
+	conn *dbus.Conn,
+	rule string,
+	wanted map[string]struct{},
+) error {
+	_, want := wanted[rule]
+	_, added := s.matches[rule]
+	switch {
+	case want && !added:
+		return s.addMatch(conn, rule)
+	case !want && added:
+		return s.removeMatch(conn, rule)
+	}
+	return nil
+}
+
+// flush does the bus work left by changes made under the tree lock:
+// it brings the match rules that changed in line with the references
+// held on them and removes the objects of owners found to have left
+// the bus before their match rule was added. Failures are logged and
+// left for SyncMatches.
+func (mgr *BusManager) flush() {
+	s := mgr.state
+	s.refMu.Lock()
+	if len(s.dirty) == 0 && len(s.unchecked) == 0 {
+		s.refMu.Unlock()
+		return
+	}
+	dirty, unchecked := s.dirty, s.unchecked
+	s.dirty = make(map[string]struct{})
+	s.unchecked = make(map[string]struct{})
+	s.refMu.Unlock()
+	s.mu.Lock()
+	wanted := s.wantedMatches()
+	for rule := range dirty {
+		s.syncMatch(mgr.conn, rule, wanted)
+	}
+	s.mu.Unlock()
+	for name := range unchecked {
+		if !mgr.nameHasOwner(name) {
+			mgr.ownerGone(name)
+		}
+	}
+}
+
+// SyncMatches brings the match rules on the bus in line with those the
+// manager needs. Rules the bus refused when they were first needed,
+// such as those for owned objects, are added again and rules the bus
+// failed to remove are removed again. It returns the errors of the
+// rules that still could not be changed.
+func (mgr *BusManager) SyncMatches() error {
+	s := mgr.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wanted := s.wantedMatches()
+	var errs []error
+	for rule := range wanted {
+		if _, ok := s.matches[rule]; ok {
+			continue
+		}
+		if err := s.addMatch(mgr.conn, rule); err != nil {
+			errs = append(errs, fmt.Errorf("AddMatch %s: %w", rule, err))
+		}
+	}
+	for rule := range s.matches {
+		if _, ok := wanted[rule]; ok {
+			continue
+		}
+		if err := s.removeMatch(mgr.conn, rule); err != nil {
+			errs = append(errs,
+				fmt.Errorf("RemoveMatch %s: %w", rule, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,141 @@
+package objtree
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func hasMatch(mgr *BusManager, rule string) bool {
+	mgr.state.mu.Lock()
+	defer mgr.state.mu.Unlock()
+	_, ok := mgr.state.matches[rule]
+	return ok
+}
+
+func TestReceivesMatchFailure(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	table := map[string]interface{}{
+		"Good": func() {},
+		// the stray quote makes the match rule invalid
+		"Bad'": func() {},
+	}
+	obj := mgr.NewObjectFromTable("/foo", table)
+	if err := obj.ReceivesTable("com.example.Test", table); err == nil {
+		t.Fatal("expected the match rule to be refused")
+	}
+	if _, ok := obj.getListeners()["com.example.Test"]; ok {
+		t.Fatal("the listener should not have been added")
+	}
+	good := signalKey{"com.example.Test", "Good"}
+	mgr.state.refMu.Lock()
+	refs := mgr.state.sigref[good]
+	mgr.state.refMu.Unlock()
+	if refs != 0 || hasMatch(mgr, good.rule()) {
+		t.Fatal("the rules added before the failure should be removed")
+	}
+	delete(table, "Bad'")
+	if err := obj.ReceivesTable("com.example.Test", table); err != nil {
+		t.Fatal(err)
+	}
+	if !hasMatch(mgr, good.rule()) {
+		t.Fatal("expected the rule to be added")
+	}
+}
+
+func TestSyncMatches(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	received := make(chan string, 1)
+	table := map[string]interface{}{
+		"Changed": func(in string) { received <- in },
+	}
+	obj := mgr.NewObjectFromTable("/foo", table)
+	if err := obj.ReceivesTable("com.example.Test", table); err != nil {
+		t.Fatal(err)
+	}
+	rule := signalKey{"com.example.Test", "Changed"}.rule()
+	stale := "type='signal',member='Stale'"
+	s := mgr.state
+	s.mu.Lock()
+	// pretend the listener's rule was never added and a removal failed
+	if err := s.removeMatch(mgr.conn, rule); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.addMatch(mgr.conn, stale); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Unlock()
+	if err := mgr.SyncMatches(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasMatch(mgr, rule) || hasMatch(mgr, stale) {
+		t.Fatal("the rules were not synced")
+	}
+	client := newLoopbackClient(t, bus)
+	if err := client.Emit("/emitter", "com.example.Test.Changed", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got != "hello" {
+			t.Fatal("unexpected argument:", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for signal")
+	}
+	s.refMu.Lock()
+	s.sigref[signalKey{"com.example.Bad'", "Changed"}]++
+	s.refMu.Unlock()
+	err := mgr.SyncMatches()
+	if err == nil || !strings.Contains(err.Error(), "AddMatch") {
+		t.Fatal("expected the refused rule to be reported got:", err)
+	}
+}
+
+func TestMatchChangesOutsideTreeLock(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	table := map[string]interface{}{
+		"Changed": func() {},
+	}
+	obj := mgr.NewObjectFromTable("/foo", table)
+	if err := obj.ReceivesTable("com.example.Test", table); err != nil {
+		t.Fatal(err)
+	}
+	mgr.NewObject("/bar", &testObj{})
+	client := newLoopbackClient(t, bus)
+	// holding mu stands in for a bus that is slow to answer
+	mgr.state.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		obj.SetOwner(client.Names()[0], OwnerLifetime)
+		mgr.RemoveObject("/foo")
+	}()
+	waitFor(t, func() bool {
+		mgr.state.refMu.Lock()
+		defer mgr.state.refMu.Unlock()
+		return len(mgr.state.owners) > 0
+	})
+	lookup := make(chan bool)
+	go func() {
+		_, ok := mgr.LookupObject("/bar")
+		lookup <- ok
+	}()
+	select {
+	case ok := <-lookup:
+		if !ok {
+			t.Fatal("expected to find object")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lookups should not wait for the bus")
+	}
+	mgr.state.mu.Unlock()
+	<-done
+	rule := signalKey{"com.example.Test", "Changed"}.rule()
+	if hasMatch(mgr, rule) || hasMatch(mgr, ownerMatchRule(client.Names()[0])) {
+		t.Fatal("the match rules should be removed with the object")
+	}
+}
@@ -1,22 +1,30 @@
 package objtree
 
 import (
+	"context"
+	"fmt"
 	"github.com/godbus/dbus"
 	"github.com/godbus/dbus/introspect"
 	ireflect "github.com/jsouthworth/objtree/internal/reflect"
 	"reflect"
+	"sync"
+	"time"
 )
 
 var (
-	sendertype = reflect.TypeOf((*dbus.Sender)(nil)).Elem()
-	errtype    = reflect.TypeOf((*error)(nil)).Elem()
+	sendertype  = reflect.TypeOf((*dbus.Sender)(nil)).Elem()
+	contexttype = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errtype     = reflect.TypeOf((*error)(nil)).Elem()
 )
 
 type Method struct {
 	name    string
+	iface   string
 	impl    *ireflect.Method
+	object  *Object
 	sender  string
 	message *dbus.Message
+	trace   map[string]string
 }
 
 func (method *Method) Introspect() introspect.Method {
@@ -33,14 +41,13 @@ func (method *Method) Introspect() introspect.Method {
 					continue
 				}
 			}
-			if typ == "in" && arg == sendertype {
+			if typ == "in" && (arg == sendertype || arg == contexttype) {
 				// Hide argument from introspection
 				continue
 			}
 			iarg := introspect.Arg{
-				"",
-				dbus.SignatureOfType(arg).String(),
-				typ,
+				Type:      dbus.SignatureOfType(arg).String(),
+				Direction: typ,
 			}
 			args = append(args, iarg)
 		}
@@ -68,9 +75,8 @@ func (method *Method) DecodeArguments(
 	msg *dbus.Message,
 	args []interface{},
 ) ([]interface{}, error) {
-	body := msg.Body
 	pointers := make([]interface{}, method.NumArguments())
-	decode := make([]interface{}, 0, len(body))
+	decode := make([]interface{}, 0, len(msg.Body))
 
 	method.sender = sender
 	method.message = msg
@@ -79,29 +85,128 @@ func (method *Method) DecodeArguments(
 		tp := method.impl.ArgumentType(i)
 		val := reflect.New(tp)
 		pointers[i] = val.Interface()
-		if tp == sendertype {
+		switch tp {
+		case sendertype:
 			val.Elem().SetString(sender)
-		} else {
+		case contexttype:
+			// set by invoke
+		default:
 			decode = append(decode, pointers[i])
 		}
 	}
 
+	body, trace := splitTraceContext(msg.Body, len(decode))
+	method.trace = trace
 	if len(decode) != len(body) {
+		method.logDecodeError(fmt.Errorf("expected %d arguments got %d",
+			len(decode), len(body)))
 		return nil, dbus.ErrMsgInvalidArg
 	}
 
 	if err := dbus.Store(body, decode...); err != nil {
+		method.logDecodeError(err)
 		return nil, dbus.ErrMsgInvalidArg
 	}
 	// Deref the pointers created by reflect.New above
 	for i, ptr := range pointers {
 		pointers[i] = reflect.ValueOf(ptr).Elem().Interface()
 	}
+	if err := method.authorize(sender, pointers); err != nil {
+		return nil, err
+	}
 	return pointers, nil
 }
 
+// numWireArguments returns the number of arguments of method carried
+// in a message body, leaving out those objtree fills in itself.
+func (method *Method) numWireArguments() int {
+	n := 0
+	for i := 0; i < method.impl.NumArguments(); i++ {
+		switch method.impl.ArgumentType(i) {
+		case sendertype, contexttype:
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// signalArguments builds the arguments of a listener method from the
+// body of a signal the way DecodeArguments does for a call: sender
+// fills any dbus.Sender argument and context.Context arguments are
+// left nil for invoke to set.
+func (method *Method) signalArguments(
+	sender string,
+	body []interface{},
+) ([]interface{}, error) {
+	args := make([]interface{}, method.impl.NumArguments())
+	n := 0
+	for i := range args {
+		switch method.impl.ArgumentType(i) {
+		case sendertype:
+			args[i] = dbus.Sender(sender)
+		case contexttype:
+			// set by invoke
+		default:
+			if n < len(body) {
+				args[i] = body[n]
+			}
+			n++
+		}
+	}
+	if n != len(body) {
+		return nil, fmt.Errorf("expected %d arguments got %d",
+			n, len(body))
+	}
+	return args, nil
+}
+
+// Call calls the method through the interceptors that apply to it.
 func (method *Method) Call(args ...interface{}) ([]interface{}, error) {
-	return method.impl.Call(args...)
+	start := time.Now()
+	ctx, outs, err := method.call(args)
+	method.logCall(ctx, start, err)
+	return outs, err
+}
+
+// call runs the interceptors and the method. It also returns the
+// context the method was given, or would have been given had no
+// interceptor short-circuited the call, so that the call can be logged
+// with it.
+func (method *Method) call(
+	args []interface{},
+) (context.Context, []interface{}, error) {
+	ctx := context.Background()
+	if method.object == nil {
+		outs, err := method.invoke(ctx, args)
+		return ctx, outs, err
+	}
+	chain := method.object.interceptorChain(method.iface)
+	if len(chain) == 0 {
+		outs, err := method.invoke(ctx, args)
+		return ctx, outs, err
+	}
+	call := &CallInfo{
+		Context:   context.Background(),
+		Trace:     method.trace,
+		Sender:    method.sender,
+		Path:      method.path(),
+		Interface: method.iface,
+		Member:    method.name,
+		Args:      args,
+		method:    method,
+	}
+	var mu sync.Mutex
+	outs, err := runInterceptors(chain, call,
+		func(call *CallInfo) ([]interface{}, error) {
+			mu.Lock()
+			ctx = call.Context
+			mu.Unlock()
+			return method.invoke(call.Context, call.Args)
+		})
+	mu.Lock()
+	defer mu.Unlock()
+	return ctx, outs, err
 }
 
 func (method *Method) NumArguments() int {
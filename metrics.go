@@ -0,0 +1,114 @@
+package objtree
+
+import (
+	"github.com/godbus/dbus"
+	"path"
+	"sync"
+	"time"
+)
+
+const fdtFailed = fdtDBusName + ".Error.Failed"
+
+// MetricLabels identify what a measurement is about. Path is the first
+// pattern added to the Metrics that matched the object path, or the
+// path itself if none did.
+type MetricLabels struct {
+	Path      string
+	Interface string
+	Member    string
+}
+
+// A MetricsSink records the measurements taken by Metrics. Error is
+// the D-Bus error name a call or signal handler failed with, or empty
+// on success; errors that are not D-Bus errors are named
+// org.freedesktop.DBus.Error.Failed as they are on the bus. A sink
+// must be safe for concurrent use.
+type MetricsSink interface {
+	CallStarted(labels MetricLabels)
+	CallFinished(labels MetricLabels, err string, elapsed time.Duration)
+	SignalDelivered(labels MetricLabels, err string, elapsed time.Duration)
+}
+
+// Metrics measures method calls and signal deliveries and passes the
+// measurements to a MetricsSink. Install it with
+//
+//	mgr.Intercept(metrics.Intercept)
+//	mgr.InterceptSignals(metrics.InterceptSignal)
+//
+// or at a narrower scope. Installed ahead of other interceptors it
+// measures the calls they reject as well.
+type Metrics struct {
+	sink MetricsSink
+
+	mu       sync.Mutex
+	patterns []string
+}
+
+// NewMetrics returns Metrics reporting to sink.
+func NewMetrics(sink MetricsSink) *Metrics {
+	return &Metrics{sink: sink}
+}
+
+// AddPathPattern labels the measurements of objects whose path matches
+// pattern with the pattern rather than the path, so that objects
+// created per client or per item do not each get their own series.
+// The syntax is that of path.Match and patterns are tried in the order
+// they were added.
+func (m *Metrics) AddPathPattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.patterns = append(m.patterns, pattern)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Metrics) labels(p dbus.ObjectPath, iface, member string) MetricLabels {
+	labels := MetricLabels{
+		Path:      string(p),
+		Interface: iface,
+		Member:    member,
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, pattern := range m.patterns {
+		if ok, _ := path.Match(pattern, string(p)); ok {
+			labels.Path = pattern
+			break
+		}
+	}
+	return labels
+}
+
+// Intercept is an Interceptor measuring method calls.
+func (m *Metrics) Intercept(call *CallInfo, next CallFunc) ([]interface{}, error) {
+	labels := m.labels(call.Path, call.Interface, call.Member)
+	m.sink.CallStarted(labels)
+	start := time.Now()
+	outs, err := next(call)
+	m.sink.CallFinished(labels, errorName(err), time.Since(start))
+	return outs, err
+}
+
+// InterceptSignal is a SignalInterceptor measuring signal deliveries.
+// They are labelled with the path of the receiving object.
+func (m *Metrics) InterceptSignal(sig *SignalInfo, next SignalFunc) error {
+	labels := m.labels(sig.Receiver, sig.Interface, sig.Member)
+	start := time.Now()
+	err := next(sig)
+	m.sink.SignalDelivered(labels, errorName(err), time.Since(start))
+	return err
+}
+
+func errorName(err error) string {
+	switch err := err.(type) {
+	case nil:
+		return ""
+	case dbus.Error:
+		return err.Name
+	case *dbus.Error:
+		return err.Name
+	}
+	return fdtFailed
+}
@@ -0,0 +1,146 @@
+package objtree
+
+import (
+	"errors"
+	"github.com/godbus/dbus"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newMeteredTree(t *testing.T) (*Object, *PrometheusSink, chan string) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	sink := NewPrometheusSinkBuckets(root, []float64{0.5, 1})
+	metrics := NewMetrics(sink)
+	if err := metrics.AddPathPattern("/items/*"); err != nil {
+		t.Fatal(err)
+	}
+	root.InterceptSubtree(metrics.Intercept)
+	root.InterceptSignalsSubtree(metrics.InterceptSignal)
+	received := make(chan string, 1)
+	table := map[string]interface{}{
+		"Echo": func(in string) string { return in },
+		"Fail": func() error { return errors.New("failed") },
+		"Denied": func() error {
+			return dbus.NewError(fdtAccessDenied, nil)
+		},
+		"Changed": func(in string) { received <- in },
+	}
+	for _, p := range []dbus.ObjectPath{"/items/a", "/items/b", "/other"} {
+		obj := root.NewObjectFromTable(p, table)
+		obj.ImplementsTable("com.example.Test", table)
+	}
+	obj, _ := root.LookupObject("other")
+	obj.ReceivesTable("com.example.Test", table)
+	return root, sink, received
+}
+
+func TestMetrics(t *testing.T) {
+	root, sink, received := newMeteredTree(t)
+	call := func(p, member string, args ...interface{}) {
+		obj, _ := lookupPath(root, dbus.ObjectPath(p))
+		obj.Call("com.example.Test", member, args...)
+	}
+	call("/items/a", "Echo", "a")
+	call("/items/b", "Echo", "b")
+	call("/items/a", "Fail")
+	call("/other", "Denied")
+	root.DeliverSignal("com.example.Test", "Changed",
+		&dbus.Signal{Path: "/emitter", Body: []interface{}{"x"}})
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for signal")
+	}
+	var b strings.Builder
+	// the signal is counted after the handler returns and the objects
+	// as their events are delivered
+	waitFor(t, func() bool {
+		b.Reset()
+		sink.WriteTo(&b)
+		return strings.Contains(b.String(),
+			"objtree_signal_deliveries_total{") &&
+			strings.Contains(b.String(), "objtree_objects 3\n")
+	})
+	out := b.String()
+	expected := []string{
+		`objtree_method_calls_total{path="/items/*",interface="com.example.Test",member="Echo",result="ok"} 2`,
+		`objtree_method_calls_total{path="/items/*",interface="com.example.Test",member="Fail",result="org.freedesktop.DBus.Error.Failed"} 1`,
+		`objtree_method_calls_total{path="/other",interface="com.example.Test",member="Denied",result="org.freedesktop.DBus.Error.AccessDenied"} 1`,
+		`objtree_method_call_duration_seconds_bucket{path="/items/*",interface="com.example.Test",member="Echo",le="0.5"} 2`,
+		`objtree_method_call_duration_seconds_bucket{path="/items/*",interface="com.example.Test",member="Echo",le="+Inf"} 2`,
+		`objtree_method_call_duration_seconds_count{path="/items/*",interface="com.example.Test",member="Echo"} 2`,
+		`objtree_method_calls_in_flight{path="/items/*",interface="com.example.Test",member="Echo"} 0`,
+		`objtree_signal_deliveries_total{path="/other",interface="com.example.Test",member="Changed",result="ok"} 1`,
+		`# TYPE objtree_signal_delivery_duration_seconds histogram`,
+		// the placeholder at /items is not counted
+		`objtree_objects 3`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestMetricsInFlight(t *testing.T) {
+	sink := NewPrometheusSink(nil)
+	metrics := NewMetrics(sink)
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	go metrics.Intercept(&CallInfo{
+		Path:      "/foo",
+		Interface: "com.example.Test",
+		Member:    "Block",
+	}, func(*CallInfo) ([]interface{}, error) {
+		close(started)
+		<-unblock
+		return nil, nil
+	})
+	<-started
+	var b strings.Builder
+	sink.WriteTo(&b)
+	close(unblock)
+	line := `objtree_method_calls_in_flight{path="/foo",interface="com.example.Test",member="Block"} 1`
+	if !strings.Contains(b.String(), line) {
+		t.Fatalf("missing %q in:\n%s", line, b.String())
+	}
+	if strings.Contains(b.String(), "objtree_objects") {
+		t.Fatal("objects should only be counted with a root")
+	}
+	sink.Close()
+	if err := metrics.AddPathPattern("["); err == nil {
+		t.Fatal("expected a bad pattern to be rejected")
+	}
+}
+
+func TestMetricsObjectCount(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.NewObject("/a", &testObj{})
+	root.NewObject("/b/c", &testObj{})
+	sink := NewPrometheusSink(root)
+	defer sink.Close()
+	count := func(want string) {
+		t.Helper()
+		waitFor(t, func() bool {
+			var b strings.Builder
+			sink.WriteTo(&b)
+			return strings.Contains(b.String(),
+				"objtree_objects "+want+"\n")
+		})
+	}
+	count("2")
+	root.NewObject("/b", &testObj{})
+	root.NewObject("/a", &testObj{})
+	count("3")
+	root.DeleteObject("/b")
+	root.DeleteObject("/a")
+	count("1")
+}
+
+func TestQuoteLabel(t *testing.T) {
+	got := quoteLabel("a\\b\"c\nd")
+	if got != `"a\\b\"c\nd"` {
+		t.Fatal("unexpected escaping:", got)
+	}
+}
@@ -0,0 +1,150 @@
+package objtree
+
+import (
+	"errors"
+	"github.com/godbus/dbus"
+	"github.com/jsouthworth/objtree/internal/hamt"
+)
+
+// ErrMounted is returned when mounting an object that is already part
+// of another tree.
+var ErrMounted = errors.New("Object is already part of a tree")
+
+// NewTree returns the root placeholder of a tree that is not attached
+// to a bus. Objects can be built below it with the usual methods and
+// the result grafted into a BusManager's tree with Mount.
+func NewTree() *Object {
+	return newObjectFromImpl("", nil, nil, nil)
+}
+
+// Mount grafts the tree rooted at sub at path relative to o. Every
+// object below sub is rebound to o's tree and bus, match rules are
+// added for their listeners and subscribers of o's tree see them
+// added. sub must be the root of its own tree, as returned by NewTree
+// or Unmount; subscriptions made on that tree stop receiving events.
+// Mount returns ErrMounted if sub is attached to a parent or serves a
+// BusManager, ErrObjectExists if anything, even a placeholder, is at
+// path and a *PathError if path is invalid or is the root path.
+func (o *Object) Mount(path dbus.ObjectPath, sub *Object) error {
+	if err := validateChildPath(path); err != nil {
+		return err
+	}
+	elems := pathToStringSlice(path)
+
+	tree := o.lockTree()
+	defer tree.Unlock()
+	// sub cannot join or leave o's tree while it is locked, so this
+	// check still holds once sub's own tree is locked as well
+	if bus := sub.getBus(); sub.getParent() != nil ||
+		(bus != nil && bus.Object == sub) || sub.getTree() == tree {
+		return ErrMounted
+	}
+	subTree := sub.lockTree()
+	defer subTree.Unlock()
+	if _, exists := o.findObject(elems); exists {
+		return ErrObjectExists
+	}
+
+	nodes := sub.subtree()
+	bus := o.getBus()
+	for _, node := range nodes {
+		node.rebindListeners(node.getBus(), bus)
+		node.rebindOwner(node.getBus(), bus)
+		node.bind(bus, tree)
+	}
+	parent := o
+	if len(elems) > 1 {
+		parent = o.placeholderObject(elems[:len(elems)-1])
+	}
+	name := elems[len(elems)-1]
+	parent.objects.Update(func(value interface{}) interface{} {
+		sub.setLocation(name, parent)
+		return value.(*hamt.Map).Set(name, sub)
+	})
+	for _, node := range nodes {
+		if f := node.getFallback(); f != nil {
+			f.Flush()
+		}
+		if node.hasActions() {
+			node.emitAdded()
+		}
+	}
+	return nil
+}
+
+// Unmount detaches the object at path relative to o together with
+// everything below it and returns it as the root of a tree of its
+// own. The objects lose their bus and the match rules of their
+// listeners are removed; subscribers of o's tree see them removed.
+// The returned tree can be mounted again. Unmount returns
+// ErrObjectNotFound if nothing is at path and a *PathError if path is
+// invalid or is the root path.
+func (o *Object) Unmount(path dbus.ObjectPath) (*Object, error) {
+	if string(path) == "/" {
+		return nil, &PathError{path, "the root object cannot be unmounted"}
+	}
+	if err := ValidatePath(path); err != nil {
+		return nil, err
+	}
+	tree := o.lockTree()
+	defer tree.Unlock()
+	sub, ok := o.findObject(pathToStringSlice(path))
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+
+	nodes := sub.subtree()
+	for _, node := range nodes {
+		if node.hasActions() {
+			node.emitRemoved()
+		}
+	}
+	parent := sub.getParent()
+	parent.objects.Update(func(value interface{}) interface{} {
+		return value.(*hamt.Map).Delete(sub.getName())
+	})
+	parent.prune()
+	sub.setParent(nil)
+
+	detached := new(treeState)
+	for _, node := range nodes {
+		node.rebindListeners(node.getBus(), nil)
+		node.rebindOwner(node.getBus(), nil)
+		node.bind(nil, detached)
+		if f := node.getFallback(); f != nil {
+			f.Flush()
+		}
+	}
+	return sub, nil
+}
+
+// subtree returns o and every object below it, placeholders included.
+func (o *Object) subtree() []*Object {
+	nodes := []*Object{o}
+	for i := 0; i < len(nodes); i++ {
+		eachChild(nodes[i].getObjects(), func(_ string, child *Object) {
+			nodes = append(nodes, child)
+		})
+	}
+	return nodes
+}
+
+// rebindListeners moves the references o's listeners hold on match
+// rules from one bus to another. Either may be nil. The rules change
+// once the tree is unlocked; a rule the new bus refuses is still
+// referenced so that SyncMatches can add it later.
+func (o *Object) rebindListeners(from, to *BusManager) {
+	if from == to {
+		return
+	}
+	for ifaceName, intf := range o.getListeners() {
+		for sigName := range intf.impl.Methods() {
+			if from != nil {
+				from.state.dropMatchSignal(ifaceName, sigName)
+			}
+			if to != nil {
+				to.state.holdMatchSignal(ifaceName, sigName)
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package objtree
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMountSubtree(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	sub := NewTree()
+	obj := sub.NewObject("/eth0", &testObj{})
+	obj.Implements("foo", (*testIface)(nil))
+	child := sub.NewObject("/eth0/stats", &testObj{})
+	if err := root.Mount("/net/devices", sub); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := lookupPath(root, "/net/devices/eth0"); found != obj {
+		t.Fatal("expected mounted object")
+	}
+	if child.Path() != "/net/devices/eth0/stats" {
+		t.Fatal("unexpected path:", child.Path())
+	}
+	if sub.Parent() == nil || sub.Parent().Name() != "net" {
+		t.Fatal("expected the subtree to be attached below /net")
+	}
+	outs, err := obj.Call("foo", "CallMe")
+	if err != nil || outs[0].(string) != "hello, world" {
+		t.Fatal("interfaces should be preserved:", outs, err)
+	}
+	if obj.getTree() != root.getTree() {
+		t.Fatal("mounted objects should share the host's tree")
+	}
+}
+
+func TestMountErrors(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.NewObject("/foo/bar", &testObj{})
+	if err := root.Mount("/foo", NewTree()); err != ErrObjectExists {
+		t.Fatal("expected ErrObjectExists got:", err)
+	}
+	if err := root.Mount("/", NewTree()); err == nil {
+		t.Fatal("mounting over the root should fail")
+	}
+	if err := root.Mount("/baz", root); err != ErrMounted {
+		t.Fatal("expected ErrMounted got:", err)
+	}
+	sub := NewTree()
+	attached := sub.NewObject("/attached", &testObj{})
+	if err := root.Mount("/baz", attached); err != ErrMounted {
+		t.Fatal("expected ErrMounted got:", err)
+	}
+	if err := sub.Mount("/self", sub); err != ErrMounted {
+		t.Fatal("expected ErrMounted got:", err)
+	}
+}
+
+func TestUnmount(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	sub := NewTree()
+	obj := sub.NewObject("/eth0", &testObj{})
+	if err := root.Mount("/net/devices", sub); err != nil {
+		t.Fatal(err)
+	}
+	got, err := root.Unmount("/net/devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != sub {
+		t.Fatal("expected the mounted root back")
+	}
+	if _, ok := root.LookupObject("net"); ok {
+		t.Fatal("empty placeholders should have been pruned")
+	}
+	if obj.Path() != "/eth0" || obj.getTree() == root.getTree() {
+		t.Fatal("unmounted objects should form a tree of their own")
+	}
+	if _, err := root.Unmount("/net/devices"); err != ErrObjectNotFound {
+		t.Fatal("expected ErrObjectNotFound got:", err)
+	}
+	if err := root.Mount("/again", sub); err != nil {
+		t.Fatal("an unmounted tree should be mountable again:", err)
+	}
+}
+
+func TestMountEvents(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	s, ch := subscribe(root)
+	defer s.Close()
+	tree := NewTree()
+	obj := tree.NewObject("/foo", &testObj{})
+	obj.Implements("foo", (*testIface)(nil))
+	if err := root.Mount("/mnt", tree); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, ch,
+		Event{ObjectAdded, "/mnt/foo", ""},
+		Event{InterfaceAdded, "/mnt/foo", "foo"},
+	)
+	if _, err := root.Unmount("/mnt"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, ch,
+		Event{InterfaceRemoved, "/mnt/foo", "foo"},
+		Event{ObjectRemoved, "/mnt/foo", ""},
+	)
+}
+
+func TestLoopbackMountReceives(t *testing.T) {
+	ch := make(chan string)
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	methods := map[string]interface{}{
+		"CallMe": func(in string) {
+			ch <- in
+		},
+	}
+	tree := NewTree()
+	obj := tree.NewObjectFromTable("/bar", methods)
+	err := obj.ReceivesTable("com.github.jsouthworth.objtree.Test", methods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Mount("/foo", tree); err != nil {
+		t.Fatal(err)
+	}
+	client := newLoopbackClient(t, bus)
+	expected := "hello, world"
+	emit := func() {
+		err := client.Emit("/baz",
+			"com.github.jsouthworth.objtree.Test.CallMe", expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	emit()
+	select {
+	case got := <-ch:
+		if got != expected {
+			t.Fatal("expected:", expected, "got:", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for signal")
+	}
+	if _, err := mgr.Unmount("/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if obj.Manager() != nil {
+		t.Fatal("unmounted objects should not have a bus")
+	}
+	emit()
+	select {
+	case <-ch:
+		t.Fatal("expected timeout to occur")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,142 @@
+package objtree
+
+import (
+	"github.com/godbus/dbus"
+	"github.com/jsouthworth/objtree/internal/hamt"
+	"strings"
+)
+
+// MoveMode selects what MoveObject does with the children of the
+// object being moved.
+type MoveMode int
+
+const (
+	// MoveSubtree moves the object together with everything below
+	// it. The destination must not exist, not even as a placeholder.
+	MoveSubtree MoveMode = iota
+	// MoveObjectOnly leaves the children of the object at the old
+	// path below a placeholder. If the destination is a placeholder
+	// the object takes its place and adopts its children.
+	MoveObjectOnly
+)
+
+// MoveObject relocates the object at from to the path to, both
+// relative to o. The object keeps its identity, interfaces, listeners
+// and fallback; subscribers see it removed at the old path and added
+// at the new one, and method calls made through the bus see the move
+// happen atomically. It returns ErrObjectNotFound or ErrPlaceholder if
+// there is no object at from, ErrObjectExists if the destination is
+// taken and a *PathError if either path is invalid, is the root path
+// or if to is below from.
+func (o *Object) MoveObject(from, to dbus.ObjectPath, mode MoveMode) error {
+	for _, path := range []dbus.ObjectPath{from, to} {
+		if string(path) == "/" {
+			return &PathError{path, "the root object cannot be moved"}
+		}
+		if err := ValidatePath(path); err != nil {
+			return err
+		}
+	}
+	if to == from || strings.HasPrefix(string(to), string(from)+"/") {
+		return &PathError{to, "cannot move an object below itself"}
+	}
+	fromElems := pathToStringSlice(from)
+	toElems := pathToStringSlice(to)
+
+	tree := o.lockTree()
+	defer tree.Unlock()
+
+	obj, ok := o.findObject(fromElems)
+	switch {
+	case !ok:
+		return ErrObjectNotFound
+	case !obj.hasActions():
+		return ErrPlaceholder
+	}
+	dest, exists := o.findObject(toElems)
+	switch {
+	case exists && dest.hasActions():
+		return ErrObjectExists
+	case exists && mode == MoveSubtree:
+		return ErrObjectExists
+	}
+
+	moved := []*Object{obj}
+	if mode == MoveSubtree {
+		obj.Walk(func(_ dbus.ObjectPath, child *Object) error {
+			if child != obj {
+				moved = append(moved, child)
+			}
+			return nil
+		})
+	}
+	for _, m := range moved {
+		m.emitRemoved()
+	}
+
+	obj.detach(mode)
+	parent := o
+	if len(toElems) > 1 {
+		parent = o.placeholderObject(toElems[:len(toElems)-1])
+	}
+	obj.attach(parent, toElems[len(toElems)-1])
+
+	for _, m := range moved {
+		if f := m.getFallback(); f != nil {
+			f.Flush()
+		}
+		m.emitAdded()
+	}
+	return nil
+}
+
+// findObject looks up the object at path relative to o without
+// consulting fallbacks.
+func (o *Object) findObject(path []string) (*Object, bool) {
+	node := o
+	for _, name := range path {
+		child, ok := node.LookupObject(name)
+		if !ok {
+			return nil, false
+		}
+		node = child
+	}
+	return node, true
+}
+
+// detach takes o out of its parent. With MoveObjectOnly its children
+// stay behind below a placeholder.
+func (o *Object) detach(mode MoveMode) {
+	loc := o.getLocation()
+	parent := loc.parent
+	parent.objects.Update(func(value interface{}) interface{} {
+		objects := value.(*hamt.Map)
+		if mode == MoveObjectOnly && o.hasChildren() {
+			placeholder := newObjectFromImpl(loc.name, nil, parent,
+				parent.getBus())
+			placeholder.adoptChildren(o.getObjects())
+			o.objects.value.Store((*hamt.Map)(nil))
+			return objects.Set(loc.name, placeholder)
+		}
+		return objects.Delete(loc.name)
+	})
+	parent.prune()
+}
+
+// attach inserts o below parent as name, replacing a placeholder
+// there whose children o adopts.
+func (o *Object) attach(parent *Object, name string) {
+	parent.objects.Update(func(value interface{}) interface{} {
+		objects := value.(*hamt.Map)
+		if placeholder, ok := lookupChild(objects, name); ok {
+			o.adoptChildren(placeholder.getObjects())
+			o.inheritInterceptors(placeholder)
+			if f := placeholder.getFallback(); f != nil && !o.hasFallback() {
+				o.fallback.Store(f)
+				f.setNode(o)
+			}
+		}
+		o.setLocation(name, parent)
+		return objects.Set(name, o)
+	})
+}
@@ -0,0 +1,153 @@
+package objtree
+
+import (
+	"github.com/godbus/dbus"
+	"testing"
+	"time"
+)
+
+func TestMoveObjectSubtree(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	obj := root.NewObject("/devices/eth0", &testObj{})
+	obj.Implements("foo", (*testIface)(nil))
+	child := root.NewObject("/devices/eth0/stats", &testObj{})
+	err := root.MoveObject("/devices/eth0", "/devices/lan/wan0", MoveSubtree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := lookupPath(root, "/devices/lan/wan0"); found != obj {
+		t.Fatal("expected object at new path")
+	}
+	if found, _ := lookupPath(root, "/devices/lan/wan0/stats"); found != child {
+		t.Fatal("expected child to move with the object")
+	}
+	if obj.Path() != "/devices/lan/wan0" ||
+		child.Path() != "/devices/lan/wan0/stats" {
+		t.Fatal("unexpected paths:", obj.Path(), child.Path())
+	}
+	if _, ok := lookupPath(root, "/devices/eth0"); ok {
+		t.Fatal("object should be gone from the old path")
+	}
+	outs, err := obj.Call("foo", "CallMe")
+	if err != nil || outs[0].(string) != "hello, world" {
+		t.Fatal("interfaces should be preserved:", outs, err)
+	}
+}
+
+func TestMoveObjectPrunesPlaceholders(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.NewObject("/a/b/c", &testObj{})
+	if err := root.MoveObject("/a/b/c", "/d", MoveSubtree); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := root.LookupObject("a"); ok {
+		t.Fatal("empty placeholders should have been pruned")
+	}
+}
+
+func TestMoveObjectOnly(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	obj := root.NewObject("/foo", &testObj{})
+	child := root.NewObject("/foo/child", &testObj{})
+	orphan := root.NewObject("/bar/orphan", &testObj{})
+	if err := root.MoveObject("/foo", "/bar", MoveObjectOnly); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := lookupPath(root, "/bar"); found != obj {
+		t.Fatal("object should have replaced the placeholder")
+	}
+	if orphan.Parent() != obj || orphan.Path() != "/bar/orphan" {
+		t.Fatal("object should adopt the placeholder's children")
+	}
+	if _, ok := lookupPath(root, "/bar/child"); ok {
+		t.Fatal("children should have stayed behind")
+	}
+	if found, _ := lookupPath(root, "/foo/child"); found != child ||
+		child.Path() != "/foo/child" {
+		t.Fatal("children should still be at the old path")
+	}
+	if found, _ := lookupPath(root, "/foo"); found.hasActions() {
+		t.Fatal("old path should hold a placeholder")
+	}
+}
+
+func TestMoveObjectErrors(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.NewObject("/foo/bar", &testObj{})
+	root.NewObject("/baz", &testObj{})
+	root.NewObject("/qux/quux", &testObj{})
+	tests := []struct {
+		from, to dbus.ObjectPath
+		mode     MoveMode
+		err      error
+	}{
+		{"/missing", "/new", MoveSubtree, ErrObjectNotFound},
+		{"/foo", "/new", MoveSubtree, ErrPlaceholder},
+		{"/baz", "/foo/bar", MoveSubtree, ErrObjectExists},
+		{"/baz", "/qux", MoveSubtree, ErrObjectExists},
+	}
+	for _, test := range tests {
+		err := root.MoveObject(test.from, test.to, test.mode)
+		if err != test.err {
+			t.Fatalf("%s -> %s: expected %v got: %v",
+				test.from, test.to, test.err, err)
+		}
+	}
+	for _, paths := range [][2]dbus.ObjectPath{
+		{"/", "/new"}, {"/baz", "/"}, {"/baz", "/baz/sub"}, {"/baz", "bad"},
+	} {
+		err := root.MoveObject(paths[0], paths[1], MoveSubtree)
+		if _, ok := err.(*PathError); !ok {
+			t.Fatalf("%s -> %s: expected *PathError got: %v",
+				paths[0], paths[1], err)
+		}
+	}
+}
+
+func TestMoveObjectEvents(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.NewObject("/foo", &testObj{}).Implements("foo", (*testIface)(nil))
+	root.NewObject("/foo/bar", &testObj{})
+	sub, ch := subscribe(root)
+	defer sub.Close()
+	root.MoveObject("/foo", "/baz", MoveSubtree)
+	expectEvents(t, ch,
+		Event{InterfaceRemoved, "/foo", "foo"},
+		Event{ObjectRemoved, "/foo", ""},
+		Event{ObjectRemoved, "/foo/bar", ""},
+		Event{ObjectAdded, "/baz", ""},
+		Event{InterfaceAdded, "/baz", "foo"},
+		Event{ObjectAdded, "/baz/bar", ""},
+	)
+}
+
+func TestMoveObjectKeepsListeners(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	ch := make(chan string, 1)
+	methods := map[string]interface{}{
+		"Changed": func(in string) { ch <- in },
+	}
+	err := mgr.NewObjectFromTable("/foo", methods).
+		ReceivesTable("com.github.jsouthworth.objtree.Test", methods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.MoveObject("/foo", "/bar", MoveSubtree); err != nil {
+		t.Fatal(err)
+	}
+	client := newLoopbackClient(t, bus)
+	err = client.Emit("/baz", "com.github.jsouthworth.objtree.Test.Changed",
+		"hello, world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-ch:
+		if got != "hello, world" {
+			t.Fatal("expected: hello, world got:", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("moved object should still receive signals")
+	}
+}
@@ -1,22 +1,81 @@
 package objtree
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"github.com/godbus/dbus"
 	"github.com/godbus/dbus/introspect"
+	"github.com/jsouthworth/objtree/internal/hamt"
 	"github.com/jsouthworth/objtree/internal/reflect"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrObjectExists is returned when creating an object at a path
+	// that already has one.
+	ErrObjectExists = errors.New("Object already exists")
+	// ErrObjectNotFound is returned when there is no object at a path.
+	ErrObjectNotFound = errors.New("Object not found")
+	// ErrPlaceholder is returned when a path only names a placeholder
+	// created to hold the children of other objects.
+	ErrPlaceholder = errors.New("Object is a placeholder")
+)
+
+// CreateMode selects what happens when an object is created at a path
+// that already has one.
+type CreateMode int
+
+const (
+	// CreateExclusive fails with ErrObjectExists.
+	CreateExclusive CreateMode = iota
+	// CreateReplace replaces the existing object, keeping its
+	// children.
+	CreateReplace
 )
 
 type Object struct {
-	name       string
-	impl       *reflect.Object
-	interfaces multiWriterValue
-	listeners  multiWriterValue
-	objects    multiWriterValue
-	bus        *BusManager
-	parent     *Object
+	location     atomic.Value
+	impl         atomic.Value
+	interfaces   multiWriterValue
+	listeners    multiWriterValue
+	objects      multiWriterValue
+	fallback     atomic.Value
+	owner        atomic.Value
+	interceptors multiWriterValue
+}
+
+// location is where an object sits and which tree and bus it belongs
+// to. It changes when an object is moved or mounted, so it is replaced
+// as a whole.
+type location struct {
+	name   string
+	parent *Object
+	bus    *BusManager
+	tree   *treeState
+}
+
+// treeState is shared by every object in a tree. Structural changes
+// hold the lock for writing and lookups made on behalf of the bus hold
+// it for reading so that transactions appear atomic. bus is the
+// manager whose tree it is, if any.
+type treeState struct {
+	sync.RWMutex
+	events eventHub
+	bus    *BusManager
+}
+
+// Unlock releases the write lock and then does the bus work left by
+// the changes made under it, so that lookups never wait on the bus.
+func (t *treeState) Unlock() {
+	t.RWMutex.Unlock()
+	if t.bus != nil {
+		t.bus.flush()
+	}
 }
 
 func newObjectFromTable(
@@ -35,29 +94,145 @@ func newObjectFromImpl(
 	parent *Object,
 	bus *BusManager,
 ) *Object {
-	obj := &Object{
-		name:   name,
-		impl:   impl,
-		bus:    bus,
-		parent: parent,
+	obj := &Object{}
+	loc := location{name: name, parent: parent, bus: bus}
+	if parent != nil {
+		loc.tree = parent.getTree()
+	} else {
+		loc.tree = new(treeState)
 	}
-	obj.interfaces.value.Store(make(map[string]*Interface))
+	obj.location.Store(loc)
+	obj.impl.Store(impl)
+	obj.interfaces.value.Store(obj.standardInterfaces())
 	obj.listeners.value.Store(make(map[string]*Interface))
-	obj.objects.value.Store(make(map[string]*Object))
-	obj.addInterface(fdtIntrospectable, newIntrospection(obj))
-	obj.addInterface(fdtPeer, newPeer(obj))
+	obj.objects.value.Store((*hamt.Map)(nil))
+	obj.fallback.Store((*Fallback)(nil))
+	obj.owner.Store(ownership{})
+	obj.interceptors.value.Store((*interceptors)(nil))
 	return obj
 }
 
+func (o *Object) standardInterfaces() map[string]*Interface {
+	return map[string]*Interface{
+		fdtIntrospectable: newIntrospection(o),
+		fdtPeer:           newPeer(o),
+	}
+}
+
+func (o *Object) getLocation() location {
+	return o.location.Load().(location)
+}
+
+func (o *Object) getName() string {
+	return o.getLocation().name
+}
+
+func (o *Object) getParent() *Object {
+	return o.getLocation().parent
+}
+
+func (o *Object) getBus() *BusManager {
+	return o.getLocation().bus
+}
+
+func (o *Object) getTree() *treeState {
+	return o.getLocation().tree
+}
+
+func (o *Object) setLocation(name string, parent *Object) {
+	loc := o.getLocation()
+	loc.name, loc.parent = name, parent
+	o.location.Store(loc)
+}
+
+func (o *Object) bind(bus *BusManager, tree *treeState) {
+	loc := o.getLocation()
+	loc.bus, loc.tree = bus, tree
+	o.location.Store(loc)
+}
+
+// lockTree locks the tree o belongs to. Mounting and unmounting move
+// objects to another tree, so the lock is retaken until it is the one
+// of o's current tree.
+func (o *Object) lockTree() *treeState {
+	for {
+		tree := o.getTree()
+		tree.Lock()
+		if o.getTree() == tree {
+			return tree
+		}
+		tree.Unlock()
+	}
+}
+
+func (o *Object) rlockTree() *treeState {
+	for {
+		tree := o.getTree()
+		tree.RLock()
+		if o.getTree() == tree {
+			return tree
+		}
+		tree.RUnlock()
+	}
+}
+
+func (o *Object) setParent(parent *Object) {
+	o.setLocation(o.getName(), parent)
+}
+
+func (o *Object) getImpl() *reflect.Object {
+	return o.impl.Load().(*reflect.Object)
+}
+
+// promote turns a placeholder into a real object in place, so that its
+// children and fallback stay attached. It reports false if o already
+// has an implementation.
+func (o *Object) promote(impl *reflect.Object) bool {
+	return o.impl.CompareAndSwap((*reflect.Object)(nil), impl)
+}
+
+// adoptChildren makes o the parent of objects.
+func (o *Object) adoptChildren(objects *hamt.Map) {
+	eachChild(objects, func(_ string, child *Object) {
+		child.setParent(o)
+	})
+	o.objects.value.Store(objects)
+}
+
+// retire announces the removal of an object that is being replaced
+// and drops its listeners.
+func (o *Object) retire() {
+	if o.hasActions() {
+		o.emitRemoved()
+	}
+	o.removeListeners()
+	o.releaseOwner()
+}
+
+// demote turns o back into a placeholder, dropping its
+// implementation, interfaces, listeners and fallback.
+func (o *Object) demote() {
+	if o.hasActions() {
+		o.emitRemoved()
+	}
+	o.removeListeners()
+	o.releaseOwner()
+	o.interfaces.Update(func(interface{}) interface{} {
+		return o.standardInterfaces()
+	})
+	o.fallback.Store((*Fallback)(nil))
+	o.impl.Store((*reflect.Object)(nil))
+}
+
 func (o *Object) removeListeners() {
 	o.listeners.Update(func(value interface{}) interface{} {
 		for dbusIfaceName, intf := range value.(map[string]*Interface) {
 			for sigName, _ := range intf.impl.Methods() {
-				if o.bus == nil {
+				bus := o.getBus()
+				if bus == nil {
 					continue
 				}
-				o.bus.state.RemoveMatchSignal(o.bus.conn,
-					dbusIfaceName, sigName)
+				bus.state.dropMatchSignal(dbusIfaceName, sigName)
 
 			}
 		}
@@ -65,8 +240,26 @@ func (o *Object) removeListeners() {
 	})
 }
 
-func (o *Object) getObjects() map[string]*Object {
-	return o.objects.Load().(map[string]*Object)
+// getObjects returns the children of o. They are kept in a persistent
+// map so that adding or removing a child copies O(log N) nodes rather
+// than every entry.
+func (o *Object) getObjects() *hamt.Map {
+	return o.objects.Load().(*hamt.Map)
+}
+
+func lookupChild(objects *hamt.Map, name string) (*Object, bool) {
+	obj, ok := objects.Get(name)
+	if !ok {
+		return nil, false
+	}
+	return obj.(*Object), true
+}
+
+func eachChild(objects *hamt.Map, fn func(name string, obj *Object)) {
+	objects.Range(func(name string, obj interface{}) bool {
+		fn(name, obj.(*Object))
+		return true
+	})
 }
 
 func (o *Object) getInterfaces() map[string]*Interface {
@@ -77,22 +270,112 @@ func (o *Object) getListeners() map[string]*Interface {
 	return o.listeners.Load().(map[string]*Interface)
 }
 
-func (o *Object) newObject(path []string, impl *reflect.Object) *Object {
-	name := path[0]
-	switch len(path) {
-	case 1:
-		obj := newObjectFromImpl(name, impl, o, o.bus)
-		o.addObject(name, obj)
+func (o *Object) newObject(
+	path []string,
+	impl *reflect.Object,
+	mode CreateMode,
+) (*Object, error) {
+	parent := o
+	if len(path) > 1 {
+		//placeholder objects for introspection
+		parent = o.placeholderObject(path[:len(path)-1])
+	}
+	return parent.addObject(path[len(path)-1], impl, mode)
+}
+
+// placeholderObject returns the object at path relative to o, creating
+// placeholders for any missing elements.
+func (o *Object) placeholderObject(path []string) *Object {
+	obj := o.placeholderChild(path[0])
+	if len(path) == 1 {
 		return obj
-	default:
-		obj, ok := o.LookupObject(name)
-		if !ok {
-			//placeholder object for introspection
-			obj = newObjectFromImpl(name, nil, o, o.bus)
-			o.addObject(name, obj)
+	}
+	return obj.placeholderObject(path[1:])
+}
+
+func (o *Object) placeholderChild(name string) *Object {
+	var child *Object
+	o.objects.Update(func(value interface{}) interface{} {
+		objects := value.(*hamt.Map)
+		if obj, ok := lookupChild(objects, name); ok {
+			child = obj
+			return value
+		}
+		child = newObjectFromImpl(name, nil, o, o.getBus())
+		return objects.Set(name, child)
+	})
+	return child
+}
+
+// Path returns the full object path of o. Objects that are not part of
+// a BusManager's tree report paths relative to the top of their own
+// tree.
+func (o *Object) Path() dbus.ObjectPath {
+	loc := o.getLocation()
+	if loc.parent == nil {
+		return "/"
+	}
+	parent := loc.parent.Path()
+	if parent == "/" {
+		return dbus.ObjectPath("/" + loc.name)
+	}
+	return parent + dbus.ObjectPath("/"+loc.name)
+}
+
+// Name returns the last element of o's path.
+func (o *Object) Name() string {
+	return o.getName()
+}
+
+// Parent returns the object above o, or nil if o is the root.
+func (o *Object) Parent() *Object {
+	return o.getParent()
+}
+
+// Children returns the objects directly below o, including
+// placeholders, sorted by name.
+func (o *Object) Children() []*Object {
+	objects := o.getObjects()
+	children := make([]*Object, 0, objects.Len())
+	eachChild(objects, func(_ string, obj *Object) {
+		children = append(children, obj)
+	})
+	sort.Sort(objectsByName(children))
+	return children
+}
+
+// Interfaces returns the sorted names of the interfaces o implements,
+// including the standard interfaces every object exports.
+func (o *Object) Interfaces() []string {
+	return sortedNames(o.getInterfaces())
+}
+
+func sortedNames(interfaces map[string]*Interface) []string {
+	names := make([]string, 0, len(interfaces))
+	for name := range interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// exportedInterfaces returns the sorted names of the interfaces of o
+// other than the standard ones.
+func exportedInterfaces(o *Object) []string {
+	var names []string
+	for _, name := range sortedNames(o.getInterfaces()) {
+		if name == fdtIntrospectable || name == fdtPeer {
+			continue
 		}
-		return obj.newObject(path[1:], impl)
+		names = append(names, name)
 	}
+	return names
+}
+
+// Manager returns the BusManager whose tree o belongs to, or nil if o
+// is not attached to one.
+func (o *Object) Manager() *BusManager {
+	return o.getBus()
 }
 
 func pathToStringSlice(path dbus.ObjectPath) []string {
@@ -103,12 +386,16 @@ func pathToStringSlice(path dbus.ObjectPath) []string {
 	return ps
 }
 
+// NewObject creates an object at path relative to o, replacing any
+// object already there. path is not validated, so that relative paths
+// and labels the D-Bus specification does not allow keep working; use
+// CreateObject for paths built at run time, escaping the labels with
+// EscapePathLabel or JoinPath.
 func (o *Object) NewObject(path dbus.ObjectPath, val interface{}) *Object {
 	if string(path) == "/" {
 		return o
 	}
-	return o.newObject(pathToStringSlice(path),
-		reflect.NewObject(val))
+	return o.newUncheckedObject(path, reflect.NewObject(val))
 }
 
 func (o *Object) NewObjectFromTable(
@@ -118,8 +405,7 @@ func (o *Object) NewObjectFromTable(
 	if string(path) == "/" {
 		return o
 	}
-	return o.newObject(pathToStringSlice(path),
-		reflect.NewObjectFromTable(table))
+	return o.newUncheckedObject(path, reflect.NewObjectFromTable(table))
 }
 
 func (o *Object) NewObjectMap(
@@ -130,79 +416,202 @@ func (o *Object) NewObjectMap(
 	if string(path) == "/" {
 		return o
 	}
+	return o.newUncheckedObject(path, reflect.NewObjectMapNames(val, mapfn))
+}
+
+// newUncheckedObject creates an object the way NewObject always has,
+// splitting path on slashes without validating it.
+func (o *Object) newUncheckedObject(
+	path dbus.ObjectPath,
+	impl *reflect.Object,
+) *Object {
+	elems := pathToStringSlice(path)
+	if len(elems) == 0 {
+		return o
+	}
+	tree := o.lockTree()
+	defer tree.Unlock()
+	// replacing cannot fail
+	obj, _ := o.newObject(elems, impl, CreateReplace)
+	return obj
+}
+
+// CreateObject creates an object at path relative to o. With
+// CreateExclusive it fails with ErrObjectExists if an object is
+// already registered at path; placeholders are always replaced. A
+// *PathError is returned if path is not a valid object path or is the
+// root path, which cannot be replaced.
+func (o *Object) CreateObject(
+	path dbus.ObjectPath,
+	val interface{},
+	mode CreateMode,
+) (*Object, error) {
+	return o.CreateObjectMap(path, val,
+		func(in string) string {
+			return in
+		}, mode)
+}
+
+func (o *Object) CreateObjectFromTable(
+	path dbus.ObjectPath,
+	table map[string]interface{},
+	mode CreateMode,
+) (*Object, error) {
+	if err := validateChildPath(path); err != nil {
+		return nil, err
+	}
+	tree := o.lockTree()
+	defer tree.Unlock()
+	return o.newObject(pathToStringSlice(path),
+		reflect.NewObjectFromTable(table), mode)
+}
+
+func (o *Object) CreateObjectMap(
+	path dbus.ObjectPath,
+	val interface{},
+	mapfn func(string) string,
+	mode CreateMode,
+) (*Object, error) {
+	if err := validateChildPath(path); err != nil {
+		return nil, err
+	}
+	tree := o.lockTree()
+	defer tree.Unlock()
 	return o.newObject(pathToStringSlice(path),
-		reflect.NewObjectMapNames(val, mapfn))
+		reflect.NewObjectMapNames(val, mapfn), mode)
+}
+
+func validateChildPath(path dbus.ObjectPath) error {
+	if string(path) == "/" {
+		return &PathError{path, "the root object cannot be replaced"}
+	}
+	return ValidatePath(path)
 }
 
 func (o *Object) hasActions() bool {
-	return o.impl != nil
+	return o.getImpl() != nil
 }
 
 func (o *Object) hasChildren() bool {
-	return len(o.getObjects()) > 0
+	return o.getObjects().Len() > 0
 }
 
-func (o *Object) rmChildObject(name string) {
+func (o *Object) rmChildObject(name string) error {
+	var err error
 	o.objects.Update(func(value interface{}) interface{} {
-		objects := make(map[string]*Object)
-		for child, obj := range o.getObjects() {
-			objects[child] = obj
+		objects := value.(*hamt.Map)
+		obj, ok := lookupChild(objects, name)
+		switch {
+		case !ok:
+			err = ErrObjectNotFound
+			return value
+		case !obj.hasActions() && !obj.hasFallback():
+			err = ErrPlaceholder
+			return value
 		}
-		if obj, ok := objects[name]; ok {
-			obj.removeListeners()
-			// if there are children replace with placeholder
-			if obj.hasChildren() {
-				object := newObjectFromImpl(name, nil, o, o.bus)
-				object.objects = obj.objects
-				objects[name] = object
-			} else {
-				delete(objects, name)
-			}
+		obj.demote()
+		// if there are children keep obj as their placeholder
+		if obj.hasChildren() {
+			return value
+		}
+		return objects.Delete(name)
+	})
+	if err != nil {
+		return err
+	}
+	o.prune()
+	return nil
+}
+
+// pruneChild removes the placeholder called name once nothing is left
+// below it and continues up the tree.
+func (o *Object) pruneChild(name string) {
+	pruned := false
+	o.objects.Update(func(value interface{}) interface{} {
+		objects := value.(*hamt.Map)
+		obj, ok := lookupChild(objects, name)
+		if !ok || obj.hasActions() || obj.hasFallback() ||
+			obj.hasChildren() {
+			return value
 		}
-		return objects
+		pruned = true
+		return objects.Delete(name)
 	})
-	if !o.hasActions() && o.parent != nil {
-		o.parent.rmChildObject(o.name)
+	if pruned {
+		o.prune()
 	}
 }
 
-func (o *Object) DeleteObject(path dbus.ObjectPath) {
-	if string(path) == "/" {
-		return
+// prune removes o from its parent if it is a placeholder that nothing
+// is left below.
+func (o *Object) prune() {
+	loc := o.getLocation()
+	if !o.hasActions() && !o.hasFallback() && loc.parent != nil {
+		loc.parent.pruneChild(loc.name)
 	}
-	o.delObject(pathToStringSlice(path))
 }
 
-func (o *Object) delObject(path []string) {
-	name := path[0]
-	switch len(path) {
-	case 1:
-		if _, ok := o.LookupObject(name); ok {
-			o.rmChildObject(name)
-		}
-	default:
-		if child, ok := o.LookupObject(name); ok {
-			child.delObject(path[1:])
+// DeleteObject removes the object at path relative to o. Use
+// RemoveObject to find out why removal failed.
+func (o *Object) DeleteObject(path dbus.ObjectPath) {
+	o.RemoveObject(path)
+}
+
+// RemoveObject removes the object at path relative to o. Children of
+// the object are kept below a placeholder. It returns
+// ErrObjectNotFound if there is no object at path, ErrPlaceholder if
+// path only names a placeholder and a *PathError if path is invalid
+// or is the root path.
+func (o *Object) RemoveObject(path dbus.ObjectPath) error {
+	if string(path) == "/" {
+		return &PathError{path, "the root object cannot be removed"}
+	}
+	if err := ValidatePath(path); err != nil {
+		return err
+	}
+	tree := o.lockTree()
+	defer tree.Unlock()
+	elems := pathToStringSlice(path)
+	parent := o
+	for _, name := range elems[:len(elems)-1] {
+		child, ok := parent.LookupObject(name)
+		if !ok {
+			return ErrObjectNotFound
 		}
+		parent = child
 	}
+	return parent.rmChildObject(elems[len(elems)-1])
 }
 
+// lookupObjectPath finds the object at path relative to o, resolving
+// it with a fallback if it was not created explicitly.
 func (o *Object) lookupObjectPath(path []string) (*Object, bool) {
-	switch len(path) {
-	case 1:
-		return o.LookupObject(path[0])
+	obj, ref := o.findObjectPath(path)
+	if obj != nil {
+		return obj, true
+	}
+	obj, _ = ref.resolve()
+	return obj, obj != nil
+}
+
+// findObjectPath finds the object at path relative to o. If there is
+// none it returns the fallback that may serve path instead; resolvers
+// may change the tree, so they are left to be called once the tree
+// lock has been released.
+func (o *Object) findObjectPath(path []string) (*Object, fallbackRef) {
+	obj, ok := o.LookupObject(path[0])
+	switch {
+	case !ok:
+		return nil, o.findFallback(path)
+	case len(path) == 1:
+		return obj, fallbackRef{}
 	default:
-		obj, ok := o.LookupObject(path[0])
-		if !ok {
-			return nil, false
-		}
-		return obj.lookupObjectPath(path[1:])
+		return obj.findObjectPath(path[1:])
 	}
 }
 
 func (o *Object) LookupObject(name string) (*Object, bool) {
-	obj, ok := o.getObjects()[name]
-	return obj, ok
+	return lookupChild(o.getObjects(), name)
 }
 
 func (o *Object) LookupInterface(name string) (dbus.Interface, bool) {
@@ -217,43 +626,85 @@ func (o *Object) addInterface(name string, iface *Interface) {
 			interfaces[name] = intf
 		}
 		interfaces[name] = iface
+		o.emit(InterfaceAdded, name)
 		return interfaces
 	})
 }
 
-func (o *Object) addListener(name string, iface *Interface) {
+// addListener adds the match rules for iface's signals and then the
+// listener itself. If the bus refuses a rule, the rules already added
+// are removed again and the listener is not added.
+func (o *Object) addListener(name string, iface *Interface) error {
+	var err error
 	o.listeners.Update(func(value interface{}) interface{} {
+		if bus := o.getBus(); bus != nil {
+			var added []string
+			for method_name := range iface.impl.Methods() {
+				err = bus.state.AddMatchSignal(bus.conn,
+					name, method_name)
+				if err != nil {
+					for _, m := range added {
+						bus.state.RemoveMatchSignal(bus.conn,
+							name, m)
+					}
+					return value
+				}
+				added = append(added, method_name)
+			}
+		}
 		listeners := make(map[string]*Interface)
 		for name, intf := range value.(map[string]*Interface) {
 			listeners[name] = intf
 		}
 		listeners[name] = iface
-		if o.bus != nil {
-			for method_name, _ := range iface.impl.Methods() {
-				o.bus.state.AddMatchSignal(o.bus.conn,
-					name, method_name)
-			}
-		}
+		o.emit(ListenerAdded, name)
 		return listeners
 	})
+	return err
 }
 
-func (o *Object) addObject(name string, object *Object) {
+func (o *Object) addObject(
+	name string,
+	impl *reflect.Object,
+	mode CreateMode,
+) (*Object, error) {
+	var (
+		object *Object
+		err    error
+	)
 	o.objects.Update(func(value interface{}) interface{} {
-		objects := make(map[string]*Object)
-		for name, obj := range value.(map[string]*Object) {
-			objects[name] = obj
+		objects := value.(*hamt.Map)
+		obj, ok := lookupChild(objects, name)
+		if ok && obj.promote(impl) {
+			object = obj
+			object.emit(ObjectAdded, "")
+			return value
 		}
-		if obj, ok := objects[name]; ok {
+		if ok && mode != CreateReplace {
+			err = ErrObjectExists
+			return value
+		}
+		object = newObjectFromImpl(name, impl, o, o.getBus())
+		if ok {
 			//there may be child objects of the object that is being
 			//replaced; keep them
-			object.objects = obj.objects
+			obj.retire()
+			object.adoptChildren(obj.getObjects())
+			object.inheritInterceptors(obj)
+			if f := obj.getFallback(); f != nil {
+				object.fallback.Store(f)
+				f.setNode(object)
+			}
 		}
-		objects[name] = object
-		return objects
+		object.emit(ObjectAdded, "")
+		return objects.Set(name, object)
 	})
+	return object, err
 }
 
+// Implements exports the methods of o's implementation that make up the
+// Go interface obj as the D-Bus interface name. It returns
+// ErrPlaceholder if o has no implementation.
 func (o *Object) Implements(name string, obj interface{}) error {
 	return o.ImplementsMap(name, obj,
 		func(in string) string {
@@ -266,7 +717,11 @@ func (o *Object) ImplementsMap(
 	obj interface{},
 	mapfn func(string) string,
 ) error {
-	iface, err := o.impl.AsInterface(
+	impl := o.getImpl()
+	if impl == nil {
+		return ErrPlaceholder
+	}
+	iface, err := impl.AsInterface(
 		reflect.NewInterfaceMapNames(obj, mapfn))
 	if err != nil {
 		return err
@@ -274,12 +729,14 @@ func (o *Object) ImplementsMap(
 	return o.implementsIface(name, iface)
 }
 
+// ImplementsTable exports the methods in table as the D-Bus interface
+// name. Called on a placeholder, it binds the functions in table
+// directly and promotes the placeholder to a real object in place.
 func (o *Object) ImplementsTable(
 	name string,
 	table map[string]interface{},
 ) error {
-	iface, err := o.impl.AsInterface(
-		reflect.NewInterfaceFromTable(table))
+	iface, err := o.tableInterface(table)
 	if err != nil {
 		return err
 	}
@@ -291,21 +748,30 @@ func (o *Object) implementsIface(
 	iface *reflect.Interface,
 ) error {
 	intf := &Interface{
-		name: name,
-		impl: iface,
+		name:   name,
+		impl:   iface,
+		object: o,
 	}
 
 	o.addInterface(name, intf)
 	return nil
 }
 
-// Call for each D-Bus interface to receive signals from
+// Call for each D-Bus interface to receive signals from. If the bus
+// refuses the match rule for one of the signals, its error is returned
+// and o does not listen to the interface. Buses refuse rules for names
+// that are not valid D-Bus interface names, such as "foo", so these
+// now fail where they used to be accepted without a match rule.
 func (o *Object) Receives(
 	dbusIfaceName string,
 	obj interface{},
 	mapfn func(string) string,
 ) error {
-	iface, err := o.impl.AsInterface(
+	impl := o.getImpl()
+	if impl == nil {
+		return ErrPlaceholder
+	}
+	iface, err := impl.AsInterface(
 		reflect.NewInterfaceMapNames(obj, mapfn))
 	if err != nil {
 		return err
@@ -317,34 +783,60 @@ func (o *Object) ReceivesTable(
 	dbusIfaceName string,
 	table map[string]interface{},
 ) error {
-	iface, err := o.impl.AsInterface(
-		reflect.NewInterfaceFromTable(table))
+	iface, err := o.tableInterface(table)
 	if err != nil {
 		return err
 	}
 	return o.receivesIface(dbusIfaceName, iface)
 }
 
+// tableInterface binds table to o's implementation. A placeholder is
+// promoted with the functions in table as its implementation, so that
+// later calls to Implements and Receives find them as well.
+func (o *Object) tableInterface(
+	table map[string]interface{},
+) (*reflect.Interface, error) {
+	typ := reflect.NewInterfaceFromTable(table)
+	if impl := o.getImpl(); impl != nil {
+		return impl.AsInterface(typ)
+	}
+	impl := reflect.NewObjectFromTable(table)
+	iface, err := impl.AsInterface(typ)
+	if err != nil {
+		return nil, err
+	}
+	tree := o.lockTree()
+	promoted := o.promote(impl)
+	if promoted {
+		o.emit(ObjectAdded, "")
+	}
+	tree.Unlock()
+	if !promoted {
+		// promoted concurrently by someone else
+		return o.getImpl().AsInterface(typ)
+	}
+	return iface, nil
+}
+
 func (o *Object) receivesIface(
 	dbusIfaceName string,
 	iface *reflect.Interface,
 ) error {
 	intf := &Interface{
-		name: dbusIfaceName,
-		impl: iface,
+		name:   dbusIfaceName,
+		impl:   iface,
+		object: o,
 	}
 
-	o.addListener(dbusIfaceName, intf)
-	return nil
+	return o.addListener(dbusIfaceName, intf)
 }
 
 // Deliver the signal to this object's listeners and all child objects
 func (o *Object) DeliverSignal(iface, member string, signal *dbus.Signal) {
 	defer func() {
-		objects := o.getObjects()
-		for _, obj := range objects {
+		eachChild(o.getObjects(), func(_ string, obj *Object) {
 			obj.DeliverSignal(iface, member, signal)
-		}
+		})
 	}()
 
 	listeners := o.getListeners()
@@ -352,13 +844,45 @@ func (o *Object) DeliverSignal(iface, member string, signal *dbus.Signal) {
 	if !ok {
 		return
 	}
-	method, ok := intf.LookupMethod(member)
+	method, ok := intf.lookupMethod(member)
 	if !ok {
 		return
 	}
-	go func() {
-		method.Call(signal.Body...)
-	}()
+	go o.handleSignal(method, signal)
+}
+
+// handleSignal calls the listener method through the signal
+// interceptors that apply to o, on o's Queue if it has one, and logs
+// the delivery.
+func (o *Object) handleSignal(method *Method, signal *dbus.Signal) {
+	start := time.Now()
+	body, trace := splitTraceContext(signal.Body, method.numWireArguments())
+	args, err := method.signalArguments(signal.Sender, body)
+	if err != nil {
+		o.logSignal(method, signal, start, err)
+		return
+	}
+	chain := o.signalInterceptorChain(method.iface)
+	if len(chain) == 0 {
+		_, err := method.invoke(context.Background(), args)
+		o.logSignal(method, signal, start, err)
+		return
+	}
+	sig := &SignalInfo{
+		Context:   context.Background(),
+		Trace:     trace,
+		Sender:    signal.Sender,
+		Path:      signal.Path,
+		Receiver:  o.Path(),
+		Interface: method.iface,
+		Member:    method.name,
+		Args:      args,
+	}
+	err = runSignalInterceptors(chain, sig, func(sig *SignalInfo) error {
+		_, err := method.invoke(sig.Context, sig.Args)
+		return err
+	})
+	o.logSignal(method, signal, start, err)
 }
 
 func (o *Object) Call(
@@ -379,12 +903,40 @@ func (o *Object) Call(
 }
 
 func (o *Object) Introspect() introspect.Node {
+	var pending []fallbackChildren
+	node := o.introspect(nil, &pending)
+	addFallbackChildren(&node, pending)
+	return node
+}
+
+// fallbackChildren records a node whose fallback lists further
+// children. at holds the names leading to the node from the root of
+// the introspection.
+type fallbackChildren struct {
+	f    *Fallback
+	path dbus.ObjectPath
+	at   []string
+}
+
+// introspect describes o and the objects below it, adding the nodes
+// whose fallbacks list children to pending rather than calling the
+// enumerators, which may change the tree, so that the caller can do so
+// once the tree lock has been released.
+func (o *Object) introspect(
+	at []string,
+	pending *[]fallbackChildren,
+) introspect.Node {
 	getChildren := func() []introspect.Node {
 		children := o.getObjects()
-		out := make([]introspect.Node, 0, len(children))
-		for _, child := range children {
-			intro := child.Introspect()
+		out := make([]introspect.Node, 0, children.Len())
+		eachChild(children, func(name string, child *Object) {
+			intro := child.introspect(
+				append(at[:len(at):len(at)], name), pending)
 			out = append(out, intro)
+		})
+		if f := o.getFallback(); f != nil {
+			*pending = append(*pending,
+				fallbackChildren{f: f, path: o.Path(), at: at})
 		}
 		sort.Sort(nodesByName(out))
 		return out
@@ -405,16 +957,45 @@ func (o *Object) Introspect() introspect.Node {
 	}
 
 	node := introspect.Node{
-		Name:       o.name,
+		Name:       o.getName(),
 		Interfaces: getInterfaces(),
 		Children:   getChildren(),
 	}
 	return node
 }
 
+// addFallbackChildren adds the children listed by the fallbacks in
+// pending to the nodes below root they were recorded for.
+func addFallbackChildren(root *introspect.Node, pending []fallbackChildren) {
+	for _, p := range pending {
+		node := root
+		for _, name := range p.at {
+			i := sort.Search(len(node.Children), func(i int) bool {
+				return node.Children[i].Name >= name
+			})
+			node = &node.Children[i]
+		}
+		exists := make(map[string]bool, len(node.Children))
+		for _, child := range node.Children {
+			exists[child.Name] = true
+		}
+		for _, name := range p.f.children(p.path) {
+			if !exists[name] {
+				node.Children = append(node.Children,
+					introspect.Node{Name: name})
+			}
+		}
+		sort.Sort(nodesByName(node.Children))
+	}
+}
+
 func newIntrospection(o *Object) *Interface {
 	intro := func() string {
-		n := o.Introspect()
+		var pending []fallbackChildren
+		tree := o.rlockTree()
+		n := o.introspect(nil, &pending)
+		tree.RUnlock()
+		addFallbackChildren(&n, pending)
 		n.Name = "" // Make it work with busctl.
 		//Busctl doesn't treat the optional
 		//name attribute of the root node correctly.
@@ -430,16 +1011,20 @@ func newIntrospection(o *Object) *Interface {
 	impl, _ := reflect.NewObjectFromTable(methods).
 		AsInterface(reflect.NewInterfaceFromTable(methods))
 	return &Interface{
-		name: fdtIntrospectable,
-		impl: impl,
+		name:   fdtIntrospectable,
+		impl:   impl,
+		object: o,
 	}
 }
 
 func newPeer(o *Object) *Interface {
-	// These are actually implemented by godbus.
-	// This gives proper introspection for the interface.
-	getMachineId := func() string {
-		return ""
+	// godbus answers these itself for messages it receives, but
+	// calls routed through Object.Call need a real implementation.
+	getMachineId := func() (string, error) {
+		if bus := o.getBus(); bus != nil {
+			return bus.machineId()
+		}
+		return readMachineId()
 	}
 	ping := func() {}
 	methods := map[string]interface{}{
@@ -449,8 +1034,9 @@ func newPeer(o *Object) *Interface {
 	impl, _ := reflect.NewObjectFromTable(methods).
 		AsInterface(reflect.NewInterfaceFromTable(methods))
 	return &Interface{
-		name: fdtPeer,
-		impl: impl,
+		name:   fdtPeer,
+		impl:   impl,
+		object: o,
 	}
 }
 
@@ -465,3 +1051,9 @@ type nodesByName []introspect.Node
 func (a nodesByName) Len() int           { return len(a) }
 func (a nodesByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a nodesByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+type objectsByName []*Object
+
+func (a objectsByName) Len() int           { return len(a) }
+func (a objectsByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a objectsByName) Less(i, j int) bool { return a[i].getName() < a[j].getName() }
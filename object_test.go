@@ -3,8 +3,13 @@ package objtree
 import (
 	"bytes"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"github.com/godbus/dbus"
 	"github.com/godbus/dbus/introspect"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -975,3 +980,317 @@ func TestObjectDeleteReceiver(t *testing.T) {
 	case <-time.After(time.Second):
 	}
 }
+
+func withMachineIdFiles(t *testing.T, contents ...string) {
+	dir, err := ioutil.TempDir("", "objtree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := machineIdFiles
+	machineIdFiles = nil
+	for i, content := range contents {
+		file := filepath.Join(dir, fmt.Sprintf("machine-id%d", i))
+		if content != "" {
+			err := ioutil.WriteFile(file, []byte(content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		machineIdFiles = append(machineIdFiles, file)
+	}
+	t.Cleanup(func() {
+		machineIdFiles = old
+		os.RemoveAll(dir)
+	})
+}
+
+func TestPeerGetMachineId(t *testing.T) {
+	const expected = "0123456789abcdef0123456789abcdef"
+	withMachineIdFiles(t, expected+"\n")
+	root := newObjectFromImpl("", nil, nil, nil)
+	obj := root.NewObject("/foo/bar", &testObj{})
+	outs, err := obj.Call(fdtPeer, "GetMachineId")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outs[0].(string) != expected {
+		t.Fatal("expected:", expected, "got:", outs[0])
+	}
+}
+
+func TestPeerGetMachineIdFallback(t *testing.T) {
+	const expected = "0123456789abcdef0123456789abcdef"
+	withMachineIdFiles(t, "", "bogus", expected)
+	root := newObjectFromImpl("", nil, nil, nil)
+	outs, err := root.Call(fdtPeer, "GetMachineId")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outs[0].(string) != expected {
+		t.Fatal("expected:", expected, "got:", outs[0])
+	}
+}
+
+func TestPeerGetMachineIdMissing(t *testing.T) {
+	withMachineIdFiles(t, "", "")
+	root := newObjectFromImpl("", nil, nil, nil)
+	_, err := root.Call(fdtPeer, "GetMachineId")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPeerPing(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	outs, err := root.Call(fdtPeer, "Ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outs) != 0 {
+		t.Fatal("unexpected output:", outs)
+	}
+}
+
+func TestCreateObjectExclusive(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	obj, err := root.CreateObject("/foo/bar", &testObj{}, CreateExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = root.CreateObject("/foo/bar", &testObj{}, CreateExclusive)
+	if err != ErrObjectExists {
+		t.Fatal("expected ErrObjectExists got:", err)
+	}
+	found, _ := lookupPath(root, "/foo/bar")
+	if found != obj {
+		t.Fatal("existing object should not have been replaced")
+	}
+	// placeholders are promoted
+	if _, err := root.CreateObject("/foo", &testObj{},
+		CreateExclusive); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := lookupPath(root, "/foo/bar"); found != obj {
+		t.Fatal("children should survive promoting a placeholder")
+	}
+}
+
+func TestCreateObjectReplace(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.CreateObject("/foo", &testObj{}, CreateExclusive)
+	child, _ := root.CreateObject("/foo/bar", &testObj{}, CreateExclusive)
+	obj, err := root.CreateObject("/foo", &testObj{}, CreateReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := lookupPath(root, "/foo"); found != obj {
+		t.Fatal("object should have been replaced")
+	}
+	if found, _ := lookupPath(root, "/foo/bar"); found != child {
+		t.Fatal("children should survive replacing an object")
+	}
+}
+
+func TestCreateObjectInvalidPath(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	for _, path := range []dbus.ObjectPath{"/", "foo", "/foo/", "/f-o"} {
+		_, err := root.CreateObject(path, &testObj{}, CreateReplace)
+		var perr *PathError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%q: expected *PathError got: %v", path, err)
+		}
+	}
+}
+
+func TestRemoveObject(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.CreateObject("/foo/bar", &testObj{}, CreateExclusive)
+	if err := root.RemoveObject("/foo"); err != ErrPlaceholder {
+		t.Fatal("expected ErrPlaceholder got:", err)
+	}
+	if err := root.RemoveObject("/foo/baz"); err != ErrObjectNotFound {
+		t.Fatal("expected ErrObjectNotFound got:", err)
+	}
+	if err := root.RemoveObject("/baz/bar"); err != ErrObjectNotFound {
+		t.Fatal("expected ErrObjectNotFound got:", err)
+	}
+	var perr *PathError
+	if err := root.RemoveObject("/"); !errors.As(err, &perr) {
+		t.Fatal("expected *PathError got:", err)
+	}
+	if err := root.RemoveObject("/foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := root.LookupObject("foo"); ok {
+		t.Fatal("placeholder should have been removed")
+	}
+	if err := root.RemoveObject("/foo/bar"); err != ErrObjectNotFound {
+		t.Fatal("expected ErrObjectNotFound got:", err)
+	}
+}
+
+func TestObjectAccessors(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	bar := root.NewObject("/foo/bar", &testObj{})
+	bar.Implements("foo", (*testIface)(nil))
+	root.NewObject("/foo/baz", &testObj{})
+	if bar.Path() != "/foo/bar" || bar.Name() != "bar" {
+		t.Fatal("unexpected path:", bar.Path(), bar.Name())
+	}
+	foo := bar.Parent()
+	if foo.Path() != "/foo" || foo.Parent() != root || root.Parent() != nil {
+		t.Fatal("unexpected parent")
+	}
+	var names []string
+	for _, child := range foo.Children() {
+		names = append(names, child.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"bar", "baz"}) {
+		t.Fatal("unexpected children:", names)
+	}
+	expected := []string{"foo", fdtIntrospectable, fdtPeer}
+	if got := bar.Interfaces(); !reflect.DeepEqual(got, expected) {
+		t.Fatal("expected:", expected, "got:", got)
+	}
+	if bar.Manager() != nil {
+		t.Fatal("unexpected manager")
+	}
+}
+
+func TestPlaceholderImplementsTable(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	bar := root.NewObject("/foo/bar", &testObj{})
+	foo := bar.Parent()
+	if err := foo.Implements("foo", (*testIface)(nil)); err != ErrPlaceholder {
+		t.Fatal("expected ErrPlaceholder got:", err)
+	}
+	err := foo.ImplementsTable("foo", map[string]interface{}{
+		"CallMe": func() string { return "placeholder" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := root.LookupObject("foo"); found != foo {
+		t.Fatal("placeholder should have been promoted in place")
+	}
+	outs, err := foo.Call("foo", "CallMe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outs[0].(string) != "placeholder" {
+		t.Fatal("unexpected output:", outs[0])
+	}
+	node := root.Introspect()
+	if len(node.Children[0].Interfaces) == 0 {
+		t.Fatal("promoted placeholder should export its interfaces")
+	}
+	if found, _ := lookupPath(root, "/foo/bar"); found != bar ||
+		bar.Parent() != foo {
+		t.Fatal("children should survive promotion")
+	}
+}
+
+func TestPlaceholderImplementsTableThenImplements(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	foo := root.NewObject("/foo/bar", &testObj{}).Parent()
+	err := foo.ImplementsTable("foo", map[string]interface{}{
+		"CallMe": func() string { return "placeholder" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := foo.Implements("bar", (*testIface)(nil)); err != nil {
+		t.Fatal(err)
+	}
+	outs, err := foo.Call("bar", "CallMe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outs[0].(string) != "placeholder" {
+		t.Fatal("unexpected output:", outs[0])
+	}
+	err = foo.Receives("baz", (*testIface)(nil),
+		func(in string) string { return in })
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlaceholderPromoteAndDemote(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	bar := root.NewObject("/foo/bar", &testObj{})
+	foo := bar.Parent()
+	obj, err := root.CreateObject("/foo", &testObj{}, CreateExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj != foo {
+		t.Fatal("placeholder should have been promoted in place")
+	}
+	if err := foo.Implements("foo", (*testIface)(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.RemoveObject("/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := root.LookupObject("foo"); found != foo {
+		t.Fatal("object with children should be demoted in place")
+	}
+	if foo.hasActions() {
+		t.Fatal("object should be a placeholder")
+	}
+	if _, ok := foo.LookupInterface("foo"); ok {
+		t.Fatal("demoted object should not export interfaces")
+	}
+	if err := root.RemoveObject("/foo"); err != ErrPlaceholder {
+		t.Fatal("expected ErrPlaceholder got:", err)
+	}
+	root.RemoveObject("/foo/bar")
+	if _, ok := root.LookupObject("foo"); ok {
+		t.Fatal("placeholder should have been pruned")
+	}
+}
+
+const benchmarkObjects = 100000
+
+func benchmarkTree(n int) *Object {
+	root := newObjectFromImpl("", nil, nil, nil)
+	for i := 0; i < n; i++ {
+		root.NewObject(dbus.ObjectPath(fmt.Sprintf("/flows/f%d", i)),
+			&testObj{})
+	}
+	return root
+}
+
+func BenchmarkNewObjectFlat(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		benchmarkTree(benchmarkObjects)
+	}
+}
+
+func BenchmarkLookupObject(b *testing.B) {
+	root := benchmarkTree(benchmarkObjects)
+	flows, _ := root.LookupObject("flows")
+	names := make([]string, benchmarkObjects)
+	for i := range names {
+		names[i] = fmt.Sprintf("f%d", i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			flows.LookupObject(names[i%benchmarkObjects])
+			i++
+		}
+	})
+}
+
+func BenchmarkCreateRemoveObject(b *testing.B) {
+	root := benchmarkTree(benchmarkObjects)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		path := dbus.ObjectPath(fmt.Sprintf("/flows/new%d", i))
+		root.CreateObject(path, &testObj{}, CreateExclusive)
+		root.RemoveObject(path)
+	}
+}
@@ -0,0 +1,354 @@
+// Package objtreetest provides utilities for testing objtree services
+// end to end. A Server runs a BusManager on an in-process loopback bus
+// and talks to it through a separate client connection, so every call
+// goes through D-Bus message encoding, routing and decoding exactly as
+// it would in production.
+package objtreetest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"github.com/godbus/dbus"
+	"github.com/godbus/dbus/introspect"
+	"github.com/jsouthworth/objtree"
+	"github.com/jsouthworth/objtree/loopback"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	fdtAddMatch    = "org.freedesktop.DBus.AddMatch"
+	fdtRemoveMatch = "org.freedesktop.DBus.RemoveMatch"
+)
+
+// DefaultTimeout bounds how long the helpers wait for replies and
+// signals before failing the test.
+const DefaultTimeout = 5 * time.Second
+
+var update = flag.Bool("objtreetest.update", false,
+	"rewrite introspection golden files instead of comparing them")
+
+// Server is a BusManager attached to a private loopback bus together
+// with a client connection to that bus.
+type Server struct {
+	t      testing.TB
+	name   string
+	bus    *loopback.Bus
+	mgr    *objtree.BusManager
+	client *dbus.Conn
+}
+
+// NewServer starts a BusManager owning name on a new loopback bus and
+// calls build to populate its object tree. The server is shut down
+// when the test finishes.
+func NewServer(
+	t testing.TB,
+	name string,
+	build func(*objtree.BusManager) error,
+) *Server {
+	t.Helper()
+	bus := loopback.New()
+	mgr, err := objtree.NewBusManager(bus.DialHandler, name)
+	if err != nil {
+		bus.Close()
+		t.Fatal("objtreetest: starting bus manager:", err)
+	}
+	s := &Server{
+		t:    t,
+		name: name,
+		bus:  bus,
+		mgr:  mgr,
+	}
+	t.Cleanup(s.Close)
+	if build != nil {
+		if err := build(mgr); err != nil {
+			t.Fatal("objtreetest: building object tree:", err)
+		}
+	}
+	s.client, err = bus.Dial()
+	if err != nil {
+		t.Fatal("objtreetest: connecting client:", err)
+	}
+	if err := s.client.Auth(nil); err != nil {
+		t.Fatal("objtreetest: connecting client:", err)
+	}
+	if err := s.client.Hello(); err != nil {
+		t.Fatal("objtreetest: connecting client:", err)
+	}
+	return s
+}
+
+// Close disconnects the manager and client from the bus.
+func (s *Server) Close() {
+	s.bus.Close()
+}
+
+// Bus returns the loopback bus the server runs on, for instance to
+// set the credentials of a connection.
+func (s *Server) Bus() *loopback.Bus {
+	return s.bus
+}
+
+// Manager returns the BusManager under test.
+func (s *Server) Manager() *objtree.BusManager {
+	return s.mgr
+}
+
+// Client returns the client connection the helpers call through.
+func (s *Server) Client() *dbus.Conn {
+	return s.client
+}
+
+// Object returns a client side proxy for the object at path.
+func (s *Server) Object(path dbus.ObjectPath) dbus.BusObject {
+	return s.client.Object(s.name, path)
+}
+
+// Call invokes method on the object at path from the client
+// connection.
+func (s *Server) Call(
+	path dbus.ObjectPath,
+	iface, method string,
+	args ...interface{},
+) *Reply {
+	s.t.Helper()
+	desc := fmt.Sprintf("%s %s.%s", path, iface, method)
+	call := s.Object(path).Go(iface+"."+method, 0,
+		make(chan *dbus.Call, 1), args...)
+	select {
+	case call = <-call.Done:
+	case <-time.After(DefaultTimeout):
+		s.t.Fatal("objtreetest: timed out calling", desc)
+	}
+	return &Reply{
+		t:    s.t,
+		desc: desc,
+		Body: call.Body,
+		Err:  call.Err,
+	}
+}
+
+// Introspect returns the introspection XML of the object at path.
+func (s *Server) Introspect(path dbus.ObjectPath) string {
+	s.t.Helper()
+	var out string
+	s.Call(path, "org.freedesktop.DBus.Introspectable", "Introspect").
+		Store(&out)
+	return out
+}
+
+// ExpectIntrospection compares the introspection data of the object at
+// path with the XML stored in golden. Documents are compared
+// structurally so formatting differences are ignored. Run the tests
+// with -objtreetest.update to rewrite the golden file.
+func (s *Server) ExpectIntrospection(path dbus.ObjectPath, golden string) {
+	s.t.Helper()
+	got := s.Introspect(path)
+	if *update {
+		out, err := formatIntrospection(got)
+		if err != nil {
+			s.t.Fatal("objtreetest:", err)
+		}
+		if err := ioutil.WriteFile(golden, out, 0644); err != nil {
+			s.t.Fatal("objtreetest:", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		s.t.Fatal("objtreetest:", err)
+	}
+	gotNode, err := decodeIntrospection([]byte(got))
+	if err != nil {
+		s.t.Fatal("objtreetest:", err)
+	}
+	wantNode, err := decodeIntrospection(want)
+	if err != nil {
+		s.t.Fatalf("objtreetest: %s: %s", golden, err)
+	}
+	if !reflect.DeepEqual(gotNode, wantNode) {
+		s.t.Fatalf("objtreetest: introspection of %s does not match %s\n"+
+			"expected:\n%s\ngot:\n%s", path, golden, want, got)
+	}
+}
+
+// ExpectProperty reads prop through org.freedesktop.DBus.Properties
+// and fails the test unless it equals want.
+func (s *Server) ExpectProperty(
+	path dbus.ObjectPath,
+	iface, prop string,
+	want interface{},
+) {
+	s.t.Helper()
+	var got dbus.Variant
+	s.Call(path, "org.freedesktop.DBus.Properties", "Get", iface, prop).
+		Store(&got)
+	if !reflect.DeepEqual(got.Value(), want) {
+		s.t.Fatalf("objtreetest: property %s.%s on %s: "+
+			"expected %#v got %#v", iface, prop, path, want, got.Value())
+	}
+}
+
+// Emit sends a signal from the client connection.
+func (s *Server) Emit(
+	path dbus.ObjectPath,
+	name string,
+	values ...interface{},
+) {
+	s.t.Helper()
+	if err := s.client.Emit(path, name, values...); err != nil {
+		s.t.Fatal("objtreetest: emitting", name, err)
+	}
+}
+
+// WatchSignals subscribes the client to the signals selected by the
+// match rule. The watcher is removed when the test finishes.
+func (s *Server) WatchSignals(rule string) *SignalWatcher {
+	s.t.Helper()
+	w := &SignalWatcher{
+		t:      s.t,
+		client: s.client,
+		rule:   rule,
+		ch:     make(chan *dbus.Signal, 64),
+	}
+	err := s.client.BusObject().Call(fdtAddMatch, 0, rule).Err
+	if err != nil {
+		s.t.Fatal("objtreetest: adding match rule:", err)
+	}
+	s.client.Signal(w.ch)
+	s.t.Cleanup(w.Close)
+	return w
+}
+
+// Reply is the outcome of a method call made with Server.Call.
+type Reply struct {
+	t    testing.TB
+	desc string
+	Body []interface{}
+	Err  error
+}
+
+// Expect fails the test unless the call succeeded and returned want.
+func (r *Reply) Expect(want ...interface{}) *Reply {
+	r.t.Helper()
+	if r.Err != nil {
+		r.t.Fatalf("objtreetest: %s: unexpected error: %s", r.desc, r.Err)
+	}
+	if len(want) == 0 && len(r.Body) == 0 {
+		return r
+	}
+	if !reflect.DeepEqual(r.Body, want) {
+		r.t.Fatalf("objtreetest: %s: expected %#v got %#v",
+			r.desc, want, r.Body)
+	}
+	return r
+}
+
+// ExpectError fails the test unless the call failed with the named
+// D-Bus error.
+func (r *Reply) ExpectError(name string) *Reply {
+	r.t.Helper()
+	if r.Err == nil {
+		r.t.Fatalf("objtreetest: %s: expected error %s", r.desc, name)
+	}
+	dbusErr, ok := r.Err.(dbus.Error)
+	if !ok || dbusErr.Name != name {
+		r.t.Fatalf("objtreetest: %s: expected error %s got %s",
+			r.desc, name, r.Err)
+	}
+	return r
+}
+
+// Store fails the test if the call failed and otherwise stores the
+// returned values like dbus.Call.Store.
+func (r *Reply) Store(retvalues ...interface{}) {
+	r.t.Helper()
+	if r.Err != nil {
+		r.t.Fatalf("objtreetest: %s: unexpected error: %s", r.desc, r.Err)
+	}
+	if err := dbus.Store(r.Body, retvalues...); err != nil {
+		r.t.Fatalf("objtreetest: %s: %s", r.desc, err)
+	}
+}
+
+// SignalWatcher collects the signals received by a Server's client.
+type SignalWatcher struct {
+	t      testing.TB
+	client *dbus.Conn
+	rule   string
+	ch     chan *dbus.Signal
+	closed bool
+}
+
+// Expect waits up to within for a signal with the given
+// "interface.member" name and returns it.
+func (w *SignalWatcher) Expect(
+	name string,
+	within time.Duration,
+) *dbus.Signal {
+	w.t.Helper()
+	timeout := time.After(within)
+	for {
+		select {
+		case sig := <-w.ch:
+			if sig.Name == name {
+				return sig
+			}
+		case <-timeout:
+			w.t.Fatalf("objtreetest: no %s signal within %s", name, within)
+			return nil
+		}
+	}
+}
+
+// ExpectNone fails the test if a signal with the given name arrives
+// within the duration.
+func (w *SignalWatcher) ExpectNone(name string, within time.Duration) {
+	w.t.Helper()
+	timeout := time.After(within)
+	for {
+		select {
+		case sig := <-w.ch:
+			if sig.Name == name {
+				w.t.Fatalf("objtreetest: unexpected signal %s from %s: %v",
+					name, sig.Path, sig.Body)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// Close unsubscribes the watcher.
+func (w *SignalWatcher) Close() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.client.RemoveSignal(w.ch)
+	w.client.BusObject().Call(fdtRemoveMatch, 0, w.rule)
+}
+
+func decodeIntrospection(data []byte) (*introspect.Node, error) {
+	var node introspect.Node
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func formatIntrospection(intro string) ([]byte, error) {
+	node, err := decodeIntrospection([]byte(intro))
+	if err != nil {
+		return nil, err
+	}
+	out, err := xml.MarshalIndent(node, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
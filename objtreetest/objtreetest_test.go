@@ -0,0 +1,118 @@
+package objtreetest_test
+
+import (
+	"github.com/godbus/dbus"
+	"github.com/jsouthworth/objtree"
+	"github.com/jsouthworth/objtree/objtreetest"
+	"testing"
+	"time"
+)
+
+const testName = "com.github.jsouthworth.objtree.Test"
+
+type testIface interface {
+	CallMe() string
+	Echo(string) string
+}
+
+type testObj struct{}
+
+func (*testObj) CallMe() string              { return "hello, world" }
+func (*testObj) Echo(in string) string       { return in }
+func (*testObj) Sender(s dbus.Sender) string { return string(s) }
+
+func newTestServer(t *testing.T) *objtreetest.Server {
+	return objtreetest.NewServer(t, testName,
+		func(mgr *objtree.BusManager) error {
+			obj := mgr.NewObject("/foo/bar", &testObj{})
+			return obj.Implements(testName, (*testIface)(nil))
+		})
+}
+
+func TestCallExpect(t *testing.T) {
+	srv := newTestServer(t)
+	srv.Call("/foo/bar", testName, "CallMe").Expect("hello, world")
+	srv.Call("/foo/bar", testName, "Echo", "ping").Expect("ping")
+}
+
+func TestCallExpectError(t *testing.T) {
+	srv := newTestServer(t)
+	srv.Call("/foo/bar", testName, "Missing").
+		ExpectError("org.freedesktop.DBus.Error.UnknownMethod")
+	srv.Call("/foo/baz", testName, "CallMe").
+		ExpectError("org.freedesktop.DBus.Error.NoSuchObject")
+	srv.Call("/foo/bar", "com.github.jsouthworth.objtree.Missing",
+		"CallMe").
+		ExpectError("org.freedesktop.DBus.Error.UnknownInterface")
+}
+
+func TestCallSender(t *testing.T) {
+	srv := objtreetest.NewServer(t, testName,
+		func(mgr *objtree.BusManager) error {
+			obj := mgr.NewObject("/foo", &testObj{})
+			return obj.ImplementsTable(testName,
+				map[string]interface{}{
+					"Sender": (*testObj)(nil).Sender,
+				})
+		})
+	srv.Call("/foo", testName, "Sender").Expect(srv.Client().Names()[0])
+}
+
+func TestExpectIntrospection(t *testing.T) {
+	srv := newTestServer(t)
+	srv.ExpectIntrospection("/foo", "testdata/foo.xml")
+	srv.ExpectIntrospection("/foo/bar", "testdata/foo_bar.xml")
+}
+
+func TestExpectProperty(t *testing.T) {
+	srv := objtreetest.NewServer(t, testName,
+		func(mgr *objtree.BusManager) error {
+			get := func(iface, prop string) (dbus.Variant, error) {
+				if iface != testName || prop != "Name" {
+					return dbus.Variant{}, dbus.ErrMsgInvalidArg
+				}
+				return dbus.MakeVariant("bar"), nil
+			}
+			methods := map[string]interface{}{"Get": get}
+			obj := mgr.NewObjectFromTable("/foo/bar", methods)
+			return obj.ImplementsTable("org.freedesktop.DBus.Properties",
+				methods)
+		})
+	srv.ExpectProperty("/foo/bar", testName, "Name", "bar")
+}
+
+func TestExpectSignal(t *testing.T) {
+	srv := newTestServer(t)
+	w := srv.WatchSignals("type='signal',interface='" + testName + "'")
+	err := srv.Manager().Conn().Emit("/foo/bar", testName+".Changed",
+		"hello, world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := w.Expect(testName+".Changed", time.Second)
+	if sig.Path != "/foo/bar" || sig.Body[0].(string) != "hello, world" {
+		t.Fatal("unexpected signal:", sig)
+	}
+	w.ExpectNone(testName+".Changed", 100*time.Millisecond)
+}
+
+func TestEmitToReceiver(t *testing.T) {
+	ch := make(chan string, 1)
+	srv := objtreetest.NewServer(t, testName,
+		func(mgr *objtree.BusManager) error {
+			methods := map[string]interface{}{
+				"Changed": func(in string) { ch <- in },
+			}
+			obj := mgr.NewObjectFromTable("/foo", methods)
+			return obj.ReceivesTable(testName, methods)
+		})
+	srv.Emit("/bar", testName+".Changed", "hello, world")
+	select {
+	case got := <-ch:
+		if got != "hello, world" {
+			t.Fatal("expected: hello, world got:", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for signal delivery")
+	}
+}
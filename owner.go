@@ -0,0 +1,266 @@
+package objtree
+
+import (
+	"errors"
+	"github.com/godbus/dbus"
+	"github.com/jsouthworth/objtree/internal/hamt"
+	"strings"
+)
+
+var (
+	// ErrNotUniqueName is returned when an owner is not a unique bus
+	// name such as ":1.42".
+	ErrNotUniqueName = errors.New("Owner is not a unique bus name")
+	// ErrOwnerGone is returned when an owner has already left the bus.
+	ErrOwnerGone = errors.New("Owner is not connected to the bus")
+)
+
+// OwnerMode selects how strictly an object is tied to its owner.
+type OwnerMode int
+
+const (
+	// OwnerLifetime removes the object when its owner leaves the bus.
+	OwnerLifetime OwnerMode = iota
+	// OwnerExclusive also rejects method calls made through the bus
+	// by anyone but the owner with
+	// org.freedesktop.DBus.Error.AccessDenied. The standard
+	// Introspectable and Peer interfaces stay open to everyone.
+	OwnerExclusive
+)
+
+type ownership struct {
+	name string
+	mode OwnerMode
+}
+
+func (o *Object) getOwner() ownership {
+	return o.owner.Load().(ownership)
+}
+
+// SetOwner ties o to the client with the unique name owner. When the
+// owner leaves the bus its BusManager removes o together with every
+// object below it, as if each had been deleted. This is meant for
+// per-client objects created in response to a method call, whose
+// sender is available through a dbus.Sender argument. An empty owner
+// unties o again. Objects that are not attached to a bus keep their
+// owner and are watched once they are mounted.
+//
+// SetOwner returns ErrPlaceholder if o has no implementation,
+// ErrNotUniqueName if owner is not a unique name and ErrOwnerGone if
+// the owner has already left the bus, in which case o is left untied.
+func (o *Object) SetOwner(owner string, mode OwnerMode) error {
+	if owner != "" && !strings.HasPrefix(owner, ":") {
+		return ErrNotUniqueName
+	}
+	tree := o.lockTree()
+	if !o.hasActions() {
+		tree.Unlock()
+		return ErrPlaceholder
+	}
+	o.releaseOwner()
+	if owner == "" {
+		tree.Unlock()
+		return nil
+	}
+	want := ownership{name: owner, mode: mode}
+	o.owner.Store(want)
+	bus := o.getBus()
+	check := bus != nil && bus.watchOwner(owner, o)
+	// the match rule is added as the lock is released
+	tree.Unlock()
+	if !check || bus.nameHasOwner(owner) {
+		return nil
+	}
+	tree = o.lockTree()
+	if o.getOwner() == want {
+		o.releaseOwner()
+	}
+	tree.Unlock()
+	return ErrOwnerGone
+}
+
+// Owner returns the unique name of the client o is tied to, or the
+// empty string.
+func (o *Object) Owner() string {
+	return o.getOwner().name
+}
+
+// releaseOwner unties o from its owner.
+func (o *Object) releaseOwner() {
+	owner := o.getOwner()
+	if owner.name == "" {
+		return
+	}
+	o.owner.Store(ownership{})
+	if bus := o.getBus(); bus != nil {
+		bus.unwatchOwner(owner.name, o)
+	}
+}
+
+// rebindOwner moves the watch on o's owner from one bus to another.
+// Either may be nil.
+func (o *Object) rebindOwner(from, to *BusManager) {
+	owner := o.getOwner()
+	if owner.name == "" || from == to {
+		return
+	}
+	if from != nil {
+		from.unwatchOwner(owner.name, o)
+	}
+	if to != nil && to.watchOwner(owner.name, o) {
+		to.state.refMu.Lock()
+		to.state.unchecked[owner.name] = struct{}{}
+		to.state.refMu.Unlock()
+	}
+}
+
+// removeSubtree removes o and everything below it from the tree.
+func (o *Object) removeSubtree() {
+	parent := o.getParent()
+	if parent == nil {
+		return
+	}
+	if child, _ := parent.LookupObject(o.getName()); child != o {
+		return
+	}
+	for _, node := range o.subtree() {
+		node.retire()
+	}
+	parent.objects.Update(func(value interface{}) interface{} {
+		return value.(*hamt.Map).Delete(o.getName())
+	})
+	parent.prune()
+}
+
+const nameOwnerChangedRule = "type='signal',sender='" + fdtDBusName +
+	"',interface='" + fdtDBusName + "',member='NameOwnerChanged'"
+
+func ownerMatchRule(owner string) string {
+	return "type='signal',sender='" + fdtDBusName +
+		"',interface='" + fdtDBusName +
+		"',member='NameOwnerChanged',arg0='" + owner + "'"
+}
+
+// watchOwner registers o as owned by name, leaving the match rule for
+// name to be added by flush. It reports whether name was not watched
+// before, in which case the caller must check that name is still on
+// the bus once the rule has been added.
+func (mgr *BusManager) watchOwner(name string, o *Object) bool {
+	s := mgr.state
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	objs, watched := s.owners[name]
+	if !watched {
+		objs = make(map[*Object]struct{})
+		s.owners[name] = objs
+		s.dirty[ownerMatchRule(name)] = struct{}{}
+	}
+	objs[o] = struct{}{}
+	return !watched
+}
+
+// nameHasOwner reports whether name is connected to the bus. It
+// errs on the side of keeping objects if the bus cannot be asked.
+func (mgr *BusManager) nameHasOwner(name string) bool {
+	var connected bool
+	err := mgr.conn.BusObject().Call(fdtNameHasOwner, 0, name).
+		Store(&connected)
+	return err != nil || connected
+}
+
+func (mgr *BusManager) unwatchOwner(name string, o *Object) {
+	s := mgr.state
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	objs, ok := s.owners[name]
+	if !ok {
+		return
+	}
+	delete(objs, o)
+	if len(objs) == 0 {
+		delete(s.owners, name)
+		s.dirty[ownerMatchRule(name)] = struct{}{}
+	}
+}
+
+func (mgr *BusManager) nameOwnerChanged(signal *dbus.Signal) {
+	var name, oldOwner, newOwner string
+	if dbus.Store(signal.Body, &name, &oldOwner, &newOwner) != nil ||
+		newOwner != "" {
+		return
+	}
+	s := mgr.state
+	s.refMu.Lock()
+	_, owned := s.owners[name]
+	trackers := make([]nameTracker, 0, len(s.trackers))
+	for t := range s.trackers {
+		trackers = append(trackers, t)
+	}
+	s.refMu.Unlock()
+	for _, t := range trackers {
+		t.forget(name)
+	}
+	if owned {
+		// signals are delivered while godbus may be waiting for the
+		// tree lock to be released, so the objects are removed
+		// separately
+		go mgr.ownerGone(name)
+	}
+}
+
+// A nameTracker keeps state per connection that it drops once the
+// connection leaves the bus.
+type nameTracker interface {
+	forget(name string)
+}
+
+// trackNames makes mgr tell t about connections leaving the bus.
+func (mgr *BusManager) trackNames(t nameTracker) {
+	s := mgr.state
+	s.mu.Lock()
+	s.refMu.Lock()
+	first := len(s.trackers) == 0
+	s.trackers[t] = struct{}{}
+	s.refMu.Unlock()
+	if first {
+		s.addMatch(mgr.conn, nameOwnerChangedRule)
+	}
+	s.mu.Unlock()
+}
+
+func (mgr *BusManager) untrackNames(t nameTracker) {
+	s := mgr.state
+	s.mu.Lock()
+	s.refMu.Lock()
+	_, ok := s.trackers[t]
+	delete(s.trackers, t)
+	last := ok && len(s.trackers) == 0
+	s.refMu.Unlock()
+	if last {
+		s.removeMatch(mgr.conn, nameOwnerChangedRule)
+	}
+	s.mu.Unlock()
+}
+
+// ownerGone removes the objects owned by name.
+func (mgr *BusManager) ownerGone(name string) {
+	s := mgr.state
+	s.refMu.Lock()
+	objs, ok := s.owners[name]
+	delete(s.owners, name)
+	if ok {
+		s.dirty[ownerMatchRule(name)] = struct{}{}
+	}
+	s.refMu.Unlock()
+	if !ok {
+		return
+	}
+	// the match rule is removed as the lock is released
+	tree := mgr.lockTree()
+	defer tree.Unlock()
+	for o := range objs {
+		if o.getBus() == mgr && o.getOwner().name == name {
+			o.removeSubtree()
+		}
+	}
+}
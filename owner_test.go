@@ -0,0 +1,129 @@
+package objtree
+
+import (
+	"github.com/godbus/dbus"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
+
+func waitForRemoval(t *testing.T, mgr *BusManager, path dbus.ObjectPath) {
+	t.Helper()
+	waitFor(t, func() bool {
+		_, ok := lookupPath(mgr.Object, path)
+		return !ok
+	})
+}
+
+func TestSetOwnerRemovesOnDisconnect(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	client := newLoopbackClient(t, bus)
+	other := newLoopbackClient(t, bus)
+	defer other.Close()
+	session := mgr.NewObject("/sessions/1", &testObj{})
+	child := mgr.NewObject("/sessions/1/child", &testObj{})
+	kept := mgr.NewObject("/sessions/2", &testObj{})
+	if err := session.SetOwner(client.Names()[0], OwnerLifetime); err != nil {
+		t.Fatal(err)
+	}
+	if err := kept.SetOwner(other.Names()[0], OwnerLifetime); err != nil {
+		t.Fatal(err)
+	}
+	if session.Owner() != client.Names()[0] {
+		t.Fatal("unexpected owner:", session.Owner())
+	}
+	client.Close()
+	waitForRemoval(t, mgr, "/sessions/1")
+	if _, ok := lookupPath(mgr.Object, "/sessions/1/child"); ok {
+		t.Fatal("objects below an owned object should be removed")
+	}
+	if child.Parent() != session || session.Owner() != "" {
+		t.Fatal("removed objects should be released")
+	}
+	if found, _ := lookupPath(mgr.Object, "/sessions/2"); found != kept {
+		t.Fatal("objects of other owners should be kept")
+	}
+}
+
+func TestSetOwnerErrors(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	obj := mgr.NewObject("/foo/bar", &testObj{})
+	if err := obj.SetOwner("com.example.Name", OwnerLifetime); err != ErrNotUniqueName {
+		t.Fatal("expected ErrNotUniqueName got:", err)
+	}
+	if err := obj.SetOwner(":1.999", OwnerLifetime); err != ErrOwnerGone {
+		t.Fatal("expected ErrOwnerGone got:", err)
+	}
+	if obj.Owner() != "" {
+		t.Fatal("a failed SetOwner should leave the object untied")
+	}
+	placeholder, _ := mgr.LookupObject("/foo")
+	err := placeholder.(*Object).SetOwner(":1.1", OwnerLifetime)
+	if err != ErrPlaceholder {
+		t.Fatal("expected ErrPlaceholder got:", err)
+	}
+}
+
+func TestSetOwnerExclusive(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	owner := newLoopbackClient(t, bus)
+	defer owner.Close()
+	other := newLoopbackClient(t, bus)
+	defer other.Close()
+	obj := mgr.NewObject("/foo", &testObj{})
+	obj.Implements("com.github.jsouthworth.objtree.Test", (*testIface)(nil))
+	if err := obj.SetOwner(owner.Names()[0], OwnerExclusive); err != nil {
+		t.Fatal(err)
+	}
+	call := func(conn *dbus.Conn, method string) error {
+		return conn.Object("com.github.jsouthworth.objtree.Test", "/foo").
+			Call(method, 0).Err
+	}
+	if err := call(owner, "com.github.jsouthworth.objtree.Test.CallMe"); err != nil {
+		t.Fatal(err)
+	}
+	err := call(other, "com.github.jsouthworth.objtree.Test.CallMe")
+	dbusErr, ok := err.(dbus.Error)
+	if !ok || dbusErr.Name != fdtAccessDenied {
+		t.Fatal("expected AccessDenied got:", err)
+	}
+	if err := call(other, fdtIntrospectable+".Introspect"); err != nil {
+		t.Fatal("introspection should stay open:", err)
+	}
+	if err := obj.SetOwner("", OwnerLifetime); err != nil {
+		t.Fatal(err)
+	}
+	if err := call(other, "com.github.jsouthworth.objtree.Test.CallMe"); err != nil {
+		t.Fatal("released objects should accept any caller:", err)
+	}
+}
+
+func TestSetOwnerMount(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	client := newLoopbackClient(t, bus)
+	tree := NewTree()
+	obj := tree.NewObject("/session", &testObj{})
+	if err := obj.SetOwner(client.Names()[0], OwnerLifetime); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Mount("/mnt", tree); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	waitForRemoval(t, mgr, "/mnt/session")
+}
@@ -0,0 +1,130 @@
+package objtree
+
+import (
+	"github.com/godbus/dbus"
+	"strings"
+)
+
+// PathError describes why an object path is invalid.
+type PathError struct {
+	Path   dbus.ObjectPath
+	Reason string
+}
+
+func (e *PathError) Error() string {
+	return "Invalid object path \"" + string(e.Path) + "\": " + e.Reason
+}
+
+// ValidatePath checks that path is a valid D-Bus object path: it must
+// begin with '/', must not end with '/' unless it is the root path,
+// and consists of non-empty elements made of the characters
+// [A-Za-z0-9_].
+func ValidatePath(path dbus.ObjectPath) error {
+	s := string(path)
+	switch {
+	case s == "":
+		return &PathError{path, "path is empty"}
+	case s[0] != '/':
+		return &PathError{path, "path must begin with '/'"}
+	case s == "/":
+		return nil
+	case s[len(s)-1] == '/':
+		return &PathError{path, "path must not end with '/'"}
+	}
+	for _, elem := range strings.Split(s[1:], "/") {
+		if elem == "" {
+			return &PathError{path, "path contains an empty element"}
+		}
+		for i := 0; i < len(elem); i++ {
+			if !isPathChar(elem[i]) {
+				return &PathError{path, "element \"" + elem +
+					"\" contains invalid characters"}
+			}
+		}
+	}
+	return nil
+}
+
+func isPathChar(c byte) bool {
+	return c == '_' ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
+
+// EscapePathLabel turns an arbitrary string into a valid object path
+// element. Every byte outside [A-Za-z0-9] is replaced by '_' followed
+// by two lower case hex digits and the empty string is encoded as
+// "_". The encoding is the same one used by sd-bus.
+func EscapePathLabel(s string) string {
+	if s == "" {
+		return "_"
+	}
+	const hex = "0123456789abcdef"
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '_' && isPathChar(c) {
+			out = append(out, c)
+			continue
+		}
+		out = append(out, '_', hex[c>>4], hex[c&0xf])
+	}
+	return string(out)
+}
+
+// UnescapePathLabel reverses EscapePathLabel.
+func UnescapePathLabel(label string) (string, error) {
+	if label == "_" {
+		return "", nil
+	}
+	var out []byte
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		switch {
+		case c == '_':
+			if i+2 >= len(label) {
+				return "", &PathError{dbus.ObjectPath(label),
+					"truncated escape sequence"}
+			}
+			hi, ok1 := unhex(label[i+1])
+			lo, ok2 := unhex(label[i+2])
+			if !ok1 || !ok2 {
+				return "", &PathError{dbus.ObjectPath(label),
+					"invalid escape sequence"}
+			}
+			out = append(out, hi<<4|lo)
+			i += 2
+		case isPathChar(c):
+			out = append(out, c)
+		default:
+			return "", &PathError{dbus.ObjectPath(label),
+				"label contains invalid characters"}
+		}
+	}
+	return string(out), nil
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// JoinPath appends each label, escaped with EscapePathLabel, to base.
+func JoinPath(base dbus.ObjectPath, labels ...string) dbus.ObjectPath {
+	out := strings.TrimSuffix(string(base), "/")
+	for _, label := range labels {
+		out += "/" + EscapePathLabel(label)
+	}
+	if out == "" {
+		return "/"
+	}
+	return dbus.ObjectPath(out)
+}
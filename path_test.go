@@ -0,0 +1,167 @@
+package objtree
+
+import (
+	"github.com/godbus/dbus"
+	"strings"
+	"testing"
+)
+
+func TestValidatePath(t *testing.T) {
+	valid := []dbus.ObjectPath{
+		"/",
+		"/foo",
+		"/foo/bar_baz/Quux0",
+	}
+	for _, path := range valid {
+		if err := ValidatePath(path); err != nil {
+			t.Fatal("expected valid path:", path, err)
+		}
+	}
+	invalid := []dbus.ObjectPath{
+		"",
+		"foo",
+		"/foo/",
+		"//foo",
+		"/foo//bar",
+		"/foo/../bar",
+		"/foo/b-r",
+		"/foo/bär",
+	}
+	for _, path := range invalid {
+		err := ValidatePath(path)
+		if err == nil {
+			t.Fatal("expected invalid path:", path)
+		}
+		if _, ok := err.(*PathError); !ok {
+			t.Fatal("expected *PathError got:", err)
+		}
+	}
+}
+
+func TestEscapePathLabel(t *testing.T) {
+	tests := map[string]string{
+		"":                                     "_",
+		"foo":                                  "foo",
+		"foo_bar":                              "foo_5fbar",
+		"..":                                   "_2e_2e",
+		"a/b":                                  "a_2fb",
+		"5d6c3a1e-2f4b-4c1d-9e8f-0a1b2c3d4e5f": "5d6c3a1e_2d2f4b_2d4c1d_2d9e8f_2d0a1b2c3d4e5f",
+		"bär":                                  "b_c3_a4r",
+	}
+	for in, expected := range tests {
+		got := EscapePathLabel(in)
+		if got != expected {
+			t.Fatal("expected:", expected, "got:", got)
+		}
+		if err := ValidatePath(dbus.ObjectPath("/" + got)); err != nil {
+			t.Fatal(err)
+		}
+		back, err := UnescapePathLabel(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back != in {
+			t.Fatal("expected:", in, "got:", back)
+		}
+	}
+}
+
+func TestUnescapePathLabelInvalid(t *testing.T) {
+	for _, label := range []string{"foo_", "foo_2", "foo_zz", "a-b"} {
+		if _, err := UnescapePathLabel(label); err == nil {
+			t.Fatal("expected error for label:", label)
+		}
+	}
+}
+
+func TestJoinPath(t *testing.T) {
+	tests := []struct {
+		base     dbus.ObjectPath
+		labels   []string
+		expected dbus.ObjectPath
+	}{
+		{"/", nil, "/"},
+		{"/", []string{"foo"}, "/foo"},
+		{"/users", []string{"jane.doe", ""}, "/users/jane_2edoe/_"},
+		{"/users/", []string{"x"}, "/users/x"},
+	}
+	for _, test := range tests {
+		got := JoinPath(test.base, test.labels...)
+		if got != test.expected {
+			t.Fatal("expected:", test.expected, "got:", got)
+		}
+	}
+}
+
+func TestNewObjectInvalidPath(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	methods := map[string]interface{}{
+		"CallMe": func() string { return "hello, world" },
+	}
+	// the legacy constructors accept the paths they always have
+	for _, path := range []dbus.ObjectPath{"foo", "/foo-bar", "baz/quux"} {
+		if _, err := root.CreateObject(path, &testObj{},
+			CreateReplace); err == nil {
+			t.Fatal("expected an error for path:", path)
+		}
+		abs := "/" + dbus.ObjectPath(strings.TrimPrefix(string(path), "/"))
+		for _, create := range []func() *Object{
+			func() *Object { return root.NewObject(path, &testObj{}) },
+			func() *Object { return root.NewObjectFromTable(path, methods) },
+			func() *Object {
+				return root.NewObjectMap(path, &testObj{},
+					func(in string) string { return in })
+			},
+		} {
+			obj := create()
+			if found, ok := lookupPath(root, abs); !ok || found != obj {
+				t.Fatal("expected an object at path:", path)
+			}
+		}
+	}
+	root = newObjectFromImpl("", nil, nil, nil)
+	expectPanic := func(path dbus.ObjectPath, fn func()) {
+		t.Helper()
+		defer func() {
+			r := recover()
+			msg, _ := r.(string)
+			if !strings.Contains(msg, "Invalid object path") {
+				t.Fatal("expected a panic for path:", path, "got:", r)
+			}
+		}()
+		fn()
+	}
+	for _, path := range []dbus.ObjectPath{
+		"", "foo", "/foo/", "/foo//bar", "/foo/../bar",
+	} {
+		expectPanic(path, func() {
+			root.NewFallback(path,
+				func(dbus.ObjectPath) (interface{}, bool) {
+					return nil, false
+				})
+		})
+		if _, err := root.CreateObject(path, &testObj{},
+			CreateReplace); err == nil {
+			t.Fatal("expected an error for path:", path)
+		}
+	}
+	if root.getObjects().Len() != 0 {
+		t.Fatal("invalid paths should not create objects")
+	}
+}
+
+func TestNewObjectEscapedPath(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	path := JoinPath("/files", "../etc/passwd")
+	obj := root.NewObject(path, &testObj{})
+	if obj == nil {
+		t.Fatal("unexpected nil")
+	}
+	files, ok := root.LookupObject("files")
+	if !ok {
+		t.Fatal("expected to find object")
+	}
+	if _, ok := files.LookupObject("_2e_2e_2fetc_2fpasswd"); !ok {
+		t.Fatal("expected escaped object name")
+	}
+}
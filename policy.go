@@ -0,0 +1,203 @@
+package objtree
+
+import (
+	"errors"
+	"github.com/godbus/dbus"
+	"strings"
+	"sync"
+)
+
+const (
+	fdtGetConnectionCredentials   = fdtDBusName + ".GetConnectionCredentials"
+	fdtGetConnectionUnixUser      = fdtDBusName + ".GetConnectionUnixUser"
+	fdtGetConnectionUnixProcessID = fdtDBusName + ".GetConnectionUnixProcessID"
+)
+
+// Credentials identify the process behind a bus connection. GIDs is
+// empty if the bus does not implement GetConnectionCredentials.
+type Credentials struct {
+	UID  uint32
+	GIDs []uint32
+	PID  uint32
+}
+
+// Action is what a Rule does with the calls it matches.
+type Action int
+
+const (
+	// Deny rejects the call with AccessDenied.
+	Deny Action = iota
+	// Allow lets the call proceed.
+	Allow
+)
+
+// A Rule matches calls to Member of Interface made by a process whose
+// user is in UIDs, one of whose groups is in GIDs or whose process id
+// is in PIDs. Empty names match any interface or member and a rule
+// without any ids matches every caller.
+type Rule struct {
+	Interface string
+	Member    string
+	UIDs      []uint32
+	GIDs      []uint32
+	PIDs      []uint32
+	Action    Action
+}
+
+func (r *Rule) matches(call *CallInfo, creds *Credentials) bool {
+	if (r.Interface != "" && r.Interface != call.Interface) ||
+		(r.Member != "" && r.Member != call.Member) {
+		return false
+	}
+	if len(r.UIDs) == 0 && len(r.GIDs) == 0 && len(r.PIDs) == 0 {
+		return true
+	}
+	if containsID(r.UIDs, creds.UID) || containsID(r.PIDs, creds.PID) {
+		return true
+	}
+	for _, gid := range creds.GIDs {
+		if containsID(r.GIDs, gid) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []uint32, id uint32) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is an Authorizer that decides on the credentials of the
+// caller. Rules are checked in the order they were added and the first
+// one that matches a call decides it; calls no rule matches get the
+// default action. Credentials are queried from the bus once per
+// connection and forgotten when the connection goes away.
+type Policy struct {
+	mgr *BusManager
+	def Action
+
+	mu    sync.Mutex
+	rules []Rule
+	creds map[string]*Credentials
+	// gen counts the connections forgotten so far; credentials
+	// queried while one went away are not cached
+	gen uint64
+}
+
+// NewPolicy returns a Policy for calls received by mgr. It must be
+// installed with SetAuthorizer to take effect.
+func NewPolicy(mgr *BusManager, def Action, rules ...Rule) *Policy {
+	p := &Policy{
+		mgr:   mgr,
+		def:   def,
+		rules: rules,
+		creds: make(map[string]*Credentials),
+	}
+	mgr.trackNames(p)
+	return p
+}
+
+// AddRule appends rule to the rules of p.
+func (p *Policy) AddRule(rule Rule) {
+	p.mu.Lock()
+	p.rules = append(p.rules, rule)
+	p.mu.Unlock()
+}
+
+// Close stops p from tracking the connections on the bus. Its cached
+// credentials are dropped but it can still be used.
+func (p *Policy) Close() {
+	p.mgr.untrackNames(p)
+	p.mu.Lock()
+	p.creds = make(map[string]*Credentials)
+	p.gen++
+	p.mu.Unlock()
+}
+
+func (p *Policy) Authorize(call *CallInfo) error {
+	creds, err := p.credentials(call.Sender)
+	if err != nil {
+		return accessDenied("Unable to identify " + call.Sender +
+			": " + err.Error())
+	}
+	p.mu.Lock()
+	action := p.def
+	for i := range p.rules {
+		if p.rules[i].matches(call, creds) {
+			action = p.rules[i].Action
+			break
+		}
+	}
+	p.mu.Unlock()
+	if action == Allow {
+		return nil
+	}
+	return accessDenied(call.Sender + " may not call " +
+		call.Interface + "." + call.Member)
+}
+
+// credentials returns the cached credentials of the connection name,
+// querying the bus the first time. The answer is only cached if no
+// connection was forgotten during the query, since name may have left
+// the bus, and may be reused, before it arrived.
+func (p *Policy) credentials(name string) (*Credentials, error) {
+	p.mu.Lock()
+	creds, ok := p.creds[name]
+	gen := p.gen
+	p.mu.Unlock()
+	if ok {
+		return creds, nil
+	}
+	creds, err := p.mgr.queryCredentials(name)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(name, ":") {
+		p.mu.Lock()
+		if p.gen == gen {
+			p.creds[name] = creds
+		}
+		p.mu.Unlock()
+	}
+	return creds, nil
+}
+
+func (p *Policy) forget(name string) {
+	p.mu.Lock()
+	delete(p.creds, name)
+	p.gen++
+	p.mu.Unlock()
+}
+
+// queryCredentials asks the bus for the credentials of name, falling
+// back to the older per-field methods.
+func (mgr *BusManager) queryCredentials(name string) (*Credentials, error) {
+	var props map[string]dbus.Variant
+	err := mgr.conn.BusObject().Call(fdtGetConnectionCredentials, 0, name).
+		Store(&props)
+	if err == nil {
+		creds := new(Credentials)
+		uid, ok := props["UnixUserID"].Value().(uint32)
+		if !ok {
+			return nil, errors.New("the bus did not report a user id")
+		}
+		creds.UID = uid
+		creds.GIDs, _ = props["UnixGroupIDs"].Value().([]uint32)
+		creds.PID, _ = props["ProcessID"].Value().(uint32)
+		return creds, nil
+	}
+	creds := new(Credentials)
+	err = mgr.conn.BusObject().Call(fdtGetConnectionUnixUser, 0, name).
+		Store(&creds.UID)
+	if err != nil {
+		return nil, err
+	}
+	mgr.conn.BusObject().Call(fdtGetConnectionUnixProcessID, 0, name).
+		Store(&creds.PID)
+	return creds, nil
+}
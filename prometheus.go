@@ -0,0 +1,286 @@
+package objtree
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/godbus/dbus"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency
+// histograms kept by a PrometheusSink.
+var DefaultBuckets = []float64{
+	.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// PrometheusSink is a MetricsSink that keeps counters, gauges and
+// latency histograms in memory and writes them in the Prometheus text
+// exposition format. The promhttp package serves them over HTTP.
+type PrometheusSink struct {
+	buckets []float64
+	objects *Subscription
+
+	mu       sync.Mutex
+	calls    map[resultKey]uint64
+	signals  map[resultKey]uint64
+	inFlight map[MetricLabels]int
+	callLat  map[MetricLabels]*histogram
+	sigLat   map[MetricLabels]*histogram
+	count    int
+}
+
+type resultKey struct {
+	MetricLabels
+	result string
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	for i, bound := range buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// NewPrometheusSink returns a PrometheusSink using DefaultBuckets. If
+// root is not nil the number of objects at and below it is exported
+// as well. The objects are counted once and the count is then kept up
+// to date from the tree's events, which stop if root is mounted into
+// another tree.
+func NewPrometheusSink(root *Object) *PrometheusSink {
+	return NewPrometheusSinkBuckets(root, DefaultBuckets)
+}
+
+// NewPrometheusSinkBuckets is NewPrometheusSink with the histogram
+// bucket bounds given in increasing order.
+func NewPrometheusSinkBuckets(root *Object, buckets []float64) *PrometheusSink {
+	p := &PrometheusSink{
+		buckets:  append([]float64(nil), buckets...),
+		calls:    make(map[resultKey]uint64),
+		signals:  make(map[resultKey]uint64),
+		inFlight: make(map[MetricLabels]int),
+		callLat:  make(map[MetricLabels]*histogram),
+		sigLat:   make(map[MetricLabels]*histogram),
+	}
+	if root != nil {
+		// no object comes or goes between counting and subscribing
+		tree := root.lockTree()
+		root.Walk(func(dbus.ObjectPath, *Object) error {
+			p.count++
+			return nil
+		})
+		p.objects = root.Subscribe(p.countObjects)
+		tree.Unlock()
+	}
+	return p
+}
+
+// Close stops counting objects.
+func (p *PrometheusSink) Close() {
+	if p.objects != nil {
+		p.objects.Close()
+	}
+}
+
+func (p *PrometheusSink) countObjects(e Event) {
+	p.mu.Lock()
+	switch e.Kind {
+	case ObjectAdded:
+		p.count++
+	case ObjectRemoved:
+		p.count--
+	}
+	p.mu.Unlock()
+}
+
+func (p *PrometheusSink) CallStarted(labels MetricLabels) {
+	p.mu.Lock()
+	p.inFlight[labels]++
+	p.mu.Unlock()
+}
+
+func (p *PrometheusSink) CallFinished(
+	labels MetricLabels,
+	err string,
+	elapsed time.Duration,
+) {
+	p.mu.Lock()
+	p.inFlight[labels]--
+	p.calls[resultKey{labels, result(err)}]++
+	p.histogram(p.callLat, labels).observe(p.buckets, elapsed.Seconds())
+	p.mu.Unlock()
+}
+
+func (p *PrometheusSink) SignalDelivered(
+	labels MetricLabels,
+	err string,
+	elapsed time.Duration,
+) {
+	p.mu.Lock()
+	p.signals[resultKey{labels, result(err)}]++
+	p.histogram(p.sigLat, labels).observe(p.buckets, elapsed.Seconds())
+	p.mu.Unlock()
+}
+
+func (p *PrometheusSink) histogram(
+	hists map[MetricLabels]*histogram,
+	labels MetricLabels,
+) *histogram {
+	h, ok := hists[labels]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		hists[labels] = h
+	}
+	return h
+}
+
+func result(err string) string {
+	if err == "" {
+		return "ok"
+	}
+	return err
+}
+
+// WriteTo writes the metrics to w in the text exposition format.
+func (p *PrometheusSink) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	p.mu.Lock()
+	p.writeResults(bw, "objtree_method_calls_total",
+		"Method calls handled, by result.", p.calls)
+	p.writeHistograms(bw, "objtree_method_call_duration_seconds",
+		"Time taken to handle method calls.", p.callLat)
+	p.writeInFlight(bw)
+	p.writeResults(bw, "objtree_signal_deliveries_total",
+		"Signals delivered to listeners, by result.", p.signals)
+	p.writeHistograms(bw, "objtree_signal_delivery_duration_seconds",
+		"Time taken by signal handlers.", p.sigLat)
+	if p.objects != nil {
+		writeHeader(bw, "objtree_objects", "Objects in the tree.", "gauge")
+		fmt.Fprintf(bw, "objtree_objects %d\n", p.count)
+	}
+	p.mu.Unlock()
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (p *PrometheusSink) writeResults(
+	w io.Writer,
+	name, help string,
+	counts map[resultKey]uint64,
+) {
+	writeHeader(w, name, help, "counter")
+	keys := make([]resultKey, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].MetricLabels != keys[j].MetricLabels {
+			return labelsLess(keys[i].MetricLabels, keys[j].MetricLabels)
+		}
+		return keys[i].result < keys[j].result
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s,result=%s} %d\n", name,
+			formatLabels(key.MetricLabels), quoteLabel(key.result),
+			counts[key])
+	}
+}
+
+func (p *PrometheusSink) writeHistograms(
+	w io.Writer,
+	name, help string,
+	hists map[MetricLabels]*histogram,
+) {
+	writeHeader(w, name, help, "histogram")
+	keys := make([]MetricLabels, 0, len(hists))
+	for labels := range hists {
+		keys = append(keys, labels)
+	}
+	sortLabels(keys)
+	for _, labels := range keys {
+		h := hists[labels]
+		l := formatLabels(labels)
+		for i, bound := range p.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, l,
+				formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, l, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, l, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, l, h.count)
+	}
+}
+
+func (p *PrometheusSink) writeInFlight(w io.Writer) {
+	const name = "objtree_method_calls_in_flight"
+	writeHeader(w, name, "Method calls being handled.", "gauge")
+	keys := make([]MetricLabels, 0, len(p.inFlight))
+	for labels := range p.inFlight {
+		keys = append(keys, labels)
+	}
+	sortLabels(keys)
+	for _, labels := range keys {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, formatLabels(labels),
+			p.inFlight[labels])
+	}
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func sortLabels(labels []MetricLabels) {
+	sort.Slice(labels, func(i, j int) bool {
+		return labelsLess(labels[i], labels[j])
+	})
+}
+
+func labelsLess(a, b MetricLabels) bool {
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	}
+	if a.Interface != b.Interface {
+		return a.Interface < b.Interface
+	}
+	return a.Member < b.Member
+}
+
+func formatLabels(labels MetricLabels) string {
+	return "path=" + quoteLabel(labels.Path) +
+		",interface=" + quoteLabel(labels.Interface) +
+		",member=" + quoteLabel(labels.Member)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
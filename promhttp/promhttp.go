@@ -0,0 +1,21 @@
+// Package promhttp serves the metrics kept by an objtree.PrometheusSink
+// over HTTP, so that objtree itself does not depend on net/http.
+package promhttp
+
+import (
+	"github.com/jsouthworth/objtree"
+	"net/http"
+)
+
+// ContentType is the media type of the Prometheus text exposition
+// format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns an http.Handler writing the metrics of sink in the
+// Prometheus text exposition format, for mounting on a debug server.
+func Handler(sink *objtree.PrometheusSink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		sink.WriteTo(w)
+	})
+}
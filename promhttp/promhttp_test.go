@@ -0,0 +1,25 @@
+package promhttp_test
+
+import (
+	"github.com/jsouthworth/objtree"
+	"github.com/jsouthworth/objtree/promhttp"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	root := objtree.NewTree()
+	root.NewObject("/foo", &struct{}{})
+	sink := objtree.NewPrometheusSink(root)
+	defer sink.Close()
+	rec := httptest.NewRecorder()
+	promhttp.Handler(sink).ServeHTTP(rec,
+		httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != promhttp.ContentType {
+		t.Fatal("unexpected content type:", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "objtree_objects 1\n") {
+		t.Fatal("unexpected metrics:", rec.Body.String())
+	}
+}
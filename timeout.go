@@ -0,0 +1,181 @@
+package objtree
+
+import (
+	"context"
+	"github.com/godbus/dbus"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const fdtTimeout = fdtDBusName + ".Error.Timeout"
+
+// Timeouts bounds how long method calls may run. A call that is still
+// running when its deadline passes is answered with
+// org.freedesktop.DBus.Error.Timeout and reported; the context passed
+// to the method is cancelled so it can give up, but objtree cannot
+// stop it. Install it with
+//
+//	mgr.Intercept(timeouts.Intercept)
+//
+// or at a narrower scope. Calls made through Call are bound as well
+// and return an error wrapping context.DeadlineExceeded.
+type Timeouts struct {
+	mu      sync.Mutex
+	def     time.Duration
+	ifaces  map[string]time.Duration
+	methods map[string]time.Duration
+	report  func(call *CallInfo, elapsed time.Duration)
+
+	timedOut uint64
+	stuck    int
+}
+
+// NewTimeouts returns Timeouts giving every call def to complete. A
+// def of zero leaves calls unbounded unless their interface or method
+// has a timeout of its own.
+func NewTimeouts(def time.Duration) *Timeouts {
+	return &Timeouts{
+		def:     def,
+		ifaces:  make(map[string]time.Duration),
+		methods: make(map[string]time.Duration),
+		report:  logStuckCall,
+	}
+}
+
+// SetInterfaceTimeout bounds the calls made to the D-Bus interface
+// iface by d, overriding the default. A d of zero leaves them
+// unbounded.
+func (t *Timeouts) SetInterfaceTimeout(iface string, d time.Duration) {
+	t.mu.Lock()
+	t.ifaces[iface] = d
+	t.mu.Unlock()
+}
+
+// SetMethodTimeout bounds the calls made to member of iface by d,
+// overriding the interface and default timeouts. A d of zero leaves
+// them unbounded.
+func (t *Timeouts) SetMethodTimeout(iface, member string, d time.Duration) {
+	t.mu.Lock()
+	t.methods[iface+"."+member] = d
+	t.mu.Unlock()
+}
+
+// OnTimeout replaces the function told about each call that runs past
+// its deadline. It is called when the deadline passes and is given
+// how long the call had been running. By default the call is logged
+// with the logger of the object's BusManager.
+func (t *Timeouts) OnTimeout(fn func(call *CallInfo, elapsed time.Duration)) {
+	t.mu.Lock()
+	t.report = fn
+	t.mu.Unlock()
+}
+
+// TimedOut returns the number of calls that have run past their
+// deadline.
+func (t *Timeouts) TimedOut() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.timedOut
+}
+
+// Stuck returns the number of calls that ran past their deadline and
+// have not yet returned.
+func (t *Timeouts) Stuck() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stuck
+}
+
+func (t *Timeouts) timeout(iface, member string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if d, ok := t.methods[iface+"."+member]; ok {
+		return d
+	}
+	if d, ok := t.ifaces[iface]; ok {
+		return d
+	}
+	return t.def
+}
+
+type callResult struct {
+	outs []interface{}
+	err  error
+}
+
+// Intercept is an Interceptor enforcing t's timeouts.
+func (t *Timeouts) Intercept(call *CallInfo, next CallFunc) ([]interface{}, error) {
+	d := t.timeout(call.Interface, call.Member)
+	if d <= 0 {
+		return next(call)
+	}
+	ctx, cancel := context.WithTimeout(contextOrBackground(call.Context), d)
+	defer cancel()
+	call.Context = ctx
+	start := time.Now()
+	done := make(chan callResult, 1)
+	// the call may outlive this function so it gets its own copy
+	running := *call
+	running.Args = append([]interface{}(nil), call.Args...)
+	go func() {
+		outs, err := next(&running)
+		done <- callResult{outs, err}
+	}()
+	select {
+	case res := <-done:
+		return res.outs, res.err
+	case <-ctx.Done():
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		return nil, ctx.Err()
+	}
+	t.mu.Lock()
+	t.timedOut++
+	t.stuck++
+	report := t.report
+	t.mu.Unlock()
+	if report != nil {
+		report(call, time.Since(start))
+	}
+	go func() {
+		<-done
+		t.mu.Lock()
+		t.stuck--
+		t.mu.Unlock()
+	}()
+	if call.Sender == "" {
+		return nil, &timeoutError{call: *call, timeout: d}
+	}
+	return nil, dbus.NewError(fdtTimeout, []interface{}{
+		call.Interface + "." + call.Member + " on " +
+			string(call.Path) + " did not complete within " +
+			d.String(),
+	})
+}
+
+// timeoutError is returned to callers of Call whose call timed out.
+type timeoutError struct {
+	call    CallInfo
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return "objtree: " + e.call.Interface + "." + e.call.Member +
+		" on " + string(e.call.Path) + " did not complete within " +
+		e.timeout.String()
+}
+
+func (e *timeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+func logStuckCall(call *CallInfo, elapsed time.Duration) {
+	call.logger().LogAttrs(contextOrBackground(call.Context),
+		slog.LevelWarn, "objtree: method call timed out",
+		slog.String("sender", call.Sender),
+		slog.String("path", string(call.Path)),
+		slog.String("interface", call.Interface),
+		slog.String("member", call.Member),
+		slog.Duration("elapsed", elapsed))
+}
@@ -0,0 +1,121 @@
+package objtree
+
+import (
+	"context"
+	"errors"
+	"github.com/godbus/dbus"
+	"testing"
+	"time"
+)
+
+func TestTimeoutsContext(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	table := map[string]interface{}{
+		"Wait": func(ctx context.Context, in string) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+		"Quick": func(ctx context.Context, in string) string {
+			return in
+		},
+	}
+	obj := root.NewObjectFromTable("/foo", table)
+	obj.ImplementsTable("com.example.Test", table)
+	timeouts := NewTimeouts(time.Hour)
+	timeouts.SetMethodTimeout("com.example.Test", "Wait",
+		10*time.Millisecond)
+	reported := make(chan CallInfo, 1)
+	timeouts.OnTimeout(func(call *CallInfo, elapsed time.Duration) {
+		if elapsed < 10*time.Millisecond {
+			t.Error("reported early:", elapsed)
+		}
+		reported <- *call
+	})
+	root.InterceptSubtree(timeouts.Intercept)
+	outs, err := obj.Call("com.example.Test", "Quick", nil, "hello")
+	if err != nil || outs[0].(string) != "hello" {
+		t.Fatal("unexpected result:", outs, err)
+	}
+	_, err = obj.Call("com.example.Test", "Wait", nil, "hello")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected a deadline error got:", err)
+	}
+	if call := <-reported; call.Member != "Wait" || call.Path != "/foo" {
+		t.Fatal("unexpected call reported:", call)
+	}
+	if timeouts.TimedOut() != 1 {
+		t.Fatal("expected 1 timed out call got:", timeouts.TimedOut())
+	}
+	waitFor(t, func() bool { return timeouts.Stuck() == 0 })
+	node := obj.Introspect()
+	for _, iface := range node.Interfaces {
+		if iface.Name != "com.example.Test" {
+			continue
+		}
+		for _, m := range iface.Methods {
+			if len(m.Args) != 2 {
+				t.Fatal("the context should be hidden got:", m.Args)
+			}
+		}
+	}
+}
+
+func TestTimeoutsLoopback(t *testing.T) {
+	bus, mgr, buf := newLoggingBusManager(t)
+	defer bus.Close()
+	unblock := make(chan struct{})
+	table := map[string]interface{}{
+		"Hang": func(ctx context.Context) { <-unblock },
+	}
+	obj := mgr.NewObjectFromTable("/foo", table)
+	obj.ImplementsTable("com.example.Test", table)
+	timeouts := NewTimeouts(0)
+	timeouts.SetInterfaceTimeout("com.example.Test", 10*time.Millisecond)
+	mgr.Intercept(timeouts.Intercept)
+	client := newLoopbackClient(t, bus)
+	err := client.Object("com.github.jsouthworth.objtree.Test", "/foo").
+		Call("com.example.Test.Hang", 0).Err
+	if dbusErr, ok := err.(dbus.Error); !ok || dbusErr.Name != fdtTimeout {
+		t.Fatal("expected Timeout got:", err)
+	}
+	if timeouts.Stuck() != 1 {
+		t.Fatal("expected 1 stuck call got:", timeouts.Stuck())
+	}
+	buf.waitFor(t, "level=WARN", "method call timed out", "path=/foo",
+		"member=Hang")
+	close(unblock)
+	waitFor(t, func() bool { return timeouts.Stuck() == 0 })
+}
+
+func TestSignalContext(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	type delivery struct {
+		ctx    context.Context
+		sender dbus.Sender
+		in     string
+	}
+	received := make(chan delivery, 1)
+	table := map[string]interface{}{
+		"Changed": func(ctx context.Context, sender dbus.Sender, in string) {
+			received <- delivery{ctx, sender, in}
+		},
+	}
+	obj := mgr.NewObjectFromTable("/foo", table)
+	if err := obj.ReceivesTable("com.example.Test", table); err != nil {
+		t.Fatal(err)
+	}
+	client := newLoopbackClient(t, bus)
+	if err := client.Emit("/emitter", "com.example.Test.Changed", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got.ctx == nil || got.in != "hello" ||
+			string(got.sender) != client.Names()[0] {
+			t.Fatal("unexpected delivery:", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for signal")
+	}
+}
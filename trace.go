@@ -0,0 +1,141 @@
+package objtree
+
+import (
+	"context"
+	"github.com/godbus/dbus"
+)
+
+// D-Bus has no message headers for applications to use, so trace
+// context travels as an optional trailing a{ss} argument after a
+// method's or signal's own arguments. objtree strips it before the
+// arguments are decoded, so methods and listeners never see it, and
+// peers that do not send one are unaffected. The keys and values are
+// those of the propagation format the Tracer uses, such as W3C
+// traceparent and tracestate.
+
+// splitTraceContext removes a trailing trace context from body if it
+// holds one argument more than the n the receiver expects.
+func splitTraceContext(
+	body []interface{},
+	n int,
+) ([]interface{}, map[string]string) {
+	if len(body) != n+1 {
+		return body, nil
+	}
+	carrier, ok := body[n].(map[string]string)
+	if !ok {
+		return body, nil
+	}
+	return body[:n], carrier
+}
+
+// SpanKind says what a span covers.
+type SpanKind int
+
+const (
+	// SpanCall covers the handling of a method call.
+	SpanCall SpanKind = iota
+	// SpanSignal covers the delivery of a signal to a listener.
+	SpanSignal
+)
+
+// SpanInfo describes the work a span covers. Path is the object a
+// method was called on or the receiver of a signal.
+type SpanInfo struct {
+	Kind      SpanKind
+	Sender    string
+	Path      dbus.ObjectPath
+	Interface string
+	Member    string
+}
+
+// A Span is ended once the work it covers is done, with the error the
+// method or handler returned, if any.
+type Span interface {
+	End(err error)
+}
+
+// A Tracer creates spans and propagates trace context. It is small
+// enough to be adapted to OpenTelemetry or another tracing library
+// without objtree depending on it.
+type Tracer interface {
+	// Start starts a span for the work described by info as a child
+	// of the span in ctx or, if carrier is not nil, of the remote
+	// span it describes. It returns a context holding the new span.
+	Start(ctx context.Context, info *SpanInfo,
+		carrier map[string]string) (context.Context, Span)
+	// Inject writes the trace context of the span in ctx to carrier.
+	Inject(ctx context.Context, carrier map[string]string)
+}
+
+// Tracing wraps method calls and signal deliveries in spans. The
+// context holding the span is passed to methods that take a
+// context.Context so that calls they make can be traced too. Install
+// it with
+//
+//	mgr.Intercept(tracing.Intercept)
+//	mgr.InterceptSignals(tracing.InterceptSignal)
+//
+// ahead of interceptors that should run inside the span.
+type Tracing struct {
+	tracer Tracer
+}
+
+// NewTracing returns Tracing creating spans with tracer.
+func NewTracing(tracer Tracer) *Tracing {
+	return &Tracing{tracer: tracer}
+}
+
+// Intercept is an Interceptor tracing method calls.
+func (t *Tracing) Intercept(call *CallInfo, next CallFunc) ([]interface{}, error) {
+	ctx, span := t.tracer.Start(contextOrBackground(call.Context),
+		&SpanInfo{
+			Kind:      SpanCall,
+			Sender:    call.Sender,
+			Path:      call.Path,
+			Interface: call.Interface,
+			Member:    call.Member,
+		}, call.Trace)
+	call.Context = ctx
+	outs, err := next(call)
+	span.End(err)
+	return outs, err
+}
+
+// InterceptSignal is a SignalInterceptor tracing signal deliveries.
+func (t *Tracing) InterceptSignal(sig *SignalInfo, next SignalFunc) error {
+	ctx, span := t.tracer.Start(contextOrBackground(sig.Context),
+		&SpanInfo{
+			Kind:      SpanSignal,
+			Sender:    sig.Sender,
+			Path:      sig.Receiver,
+			Interface: sig.Interface,
+			Member:    sig.Member,
+		}, sig.Trace)
+	sig.Context = ctx
+	err := next(sig)
+	span.End(err)
+	return err
+}
+
+// AppendTraceContext returns args followed by the trace context of
+// ctx, for a method call or signal sent to a peer that accepts one.
+// args is returned unchanged if ctx holds no span.
+func (t *Tracing) AppendTraceContext(
+	ctx context.Context,
+	args ...interface{},
+) []interface{} {
+	carrier := make(map[string]string)
+	t.tracer.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return args
+	}
+	return append(args, carrier)
+}
+
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
@@ -0,0 +1,161 @@
+package objtree
+
+import (
+	"context"
+	"github.com/godbus/dbus"
+	"sync"
+	"testing"
+	"time"
+)
+
+type spanKey struct{}
+
+type testSpan struct {
+	tracer *testTracer
+	info   SpanInfo
+	parent string
+	id     string
+	ended  bool
+	err    error
+}
+
+func (s *testSpan) End(err error) {
+	s.tracer.mu.Lock()
+	s.ended = true
+	s.err = err
+	s.tracer.mu.Unlock()
+}
+
+// testTracer propagates the id of the current span as "traceparent".
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(
+	ctx context.Context,
+	info *SpanInfo,
+	carrier map[string]string,
+) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &testSpan{tracer: t, info: *info, id: info.Member}
+	if parent, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+		span.parent = parent.id
+	}
+	if carrier != nil {
+		span.parent = carrier["traceparent"]
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *testTracer) Inject(ctx context.Context, carrier map[string]string) {
+	if span, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+		carrier["traceparent"] = span.id
+	}
+}
+
+func (t *testTracer) getSpans() []testSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]testSpan, len(t.spans))
+	for i, span := range t.spans {
+		out[i] = *span
+	}
+	return out
+}
+
+func TestTracingPropagation(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	tracer := &testTracer{}
+	tracing := NewTracing(tracer)
+	mgr.Intercept(tracing.Intercept)
+	var inner []interface{}
+	table := map[string]interface{}{
+		"Echo": func(ctx context.Context, in string) string {
+			inner = tracing.AppendTraceContext(ctx, in)
+			return in
+		},
+	}
+	obj := mgr.NewObjectFromTable("/foo", table)
+	obj.ImplementsTable("com.example.Test", table)
+	client := newLoopbackClient(t, bus)
+	remote := client.Object("com.github.jsouthworth.objtree.Test", "/foo")
+	var out string
+	err := remote.Call("com.example.Test.Echo", 0, "hello",
+		map[string]string{"traceparent": "remote"}).Store(&out)
+	if err != nil || out != "hello" {
+		t.Fatal("unexpected result:", out, err)
+	}
+	if err := remote.Call("com.example.Test.Echo", 0, "bye").Err; err != nil {
+		t.Fatal("the trace context should be optional got:", err)
+	}
+	spans := tracer.getSpans()
+	if len(spans) != 2 {
+		t.Fatal("expected 2 spans got:", len(spans))
+	}
+	span := spans[0]
+	if span.parent != "remote" || !span.ended ||
+		span.info.Sender != client.Names()[0] || span.info.Path != "/foo" ||
+		span.info.Interface != "com.example.Test" || span.info.Kind != SpanCall {
+		t.Fatal("unexpected span:", span)
+	}
+	if spans[1].parent != "" {
+		t.Fatal("a call without trace context should start a new trace")
+	}
+	carrier, ok := inner[1].(map[string]string)
+	if len(inner) != 2 || !ok || carrier["traceparent"] != "Echo" {
+		t.Fatal("the method's context should carry its span got:", inner)
+	}
+	if got := tracing.AppendTraceContext(context.Background(), "x"); len(got) != 1 {
+		t.Fatal("no trace context should be added without a span")
+	}
+}
+
+func TestTracingSignals(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	tracer := &testTracer{}
+	tracing := NewTracing(tracer)
+	root.InterceptSignalsSubtree(tracing.InterceptSignal)
+	received := make(chan string, 2)
+	table := map[string]interface{}{
+		"Changed": func(in string) { received <- in },
+		"Traced": func(ctx context.Context, in string) {
+			carrier := tracing.AppendTraceContext(ctx)[0]
+			received <- in + " " + carrier.(map[string]string)["traceparent"]
+		},
+	}
+	obj := root.NewObjectFromTable("/foo", table)
+	obj.ReceivesTable("com.example.Test", table)
+	deliver := func(member, want string) {
+		t.Helper()
+		root.DeliverSignal("com.example.Test", member, &dbus.Signal{
+			Path: "/emitter",
+			Body: []interface{}{"hello",
+				map[string]string{"traceparent": "emitter"}},
+		})
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatal("unexpected argument:", got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for signal")
+		}
+	}
+	deliver("Changed", "hello")
+	// the context argument is not on the wire
+	deliver("Traced", "hello Traced")
+	waitFor(t, func() bool {
+		spans := tracer.getSpans()
+		return len(spans) == 2 && spans[0].ended && spans[1].ended
+	})
+	for _, span := range tracer.getSpans() {
+		if span.parent != "emitter" || span.info.Kind != SpanSignal ||
+			span.info.Path != "/foo" {
+			t.Fatal("unexpected span:", span)
+		}
+	}
+}
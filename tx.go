@@ -0,0 +1,446 @@
+package objtree
+
+import (
+	"errors"
+	"github.com/godbus/dbus"
+	"github.com/jsouthworth/objtree/internal/hamt"
+	"github.com/jsouthworth/objtree/internal/reflect"
+)
+
+const (
+	fdtObjectManager     = fdtDBusName + ".ObjectManager"
+	fdtInterfacesAdded   = fdtObjectManager + ".InterfacesAdded"
+	fdtInterfacesRemoved = fdtObjectManager + ".InterfacesRemoved"
+)
+
+// ErrTxDone is returned when using a transaction that has already been
+// committed or rolled back.
+var ErrTxDone = errors.New("Transaction has already been committed or rolled back")
+
+// Change describes how the interfaces exported at a path differ
+// before and after a transaction. The standard interfaces every
+// object exports are not reported.
+type Change struct {
+	Path    dbus.ObjectPath
+	Added   []string
+	Removed []string
+}
+
+// A Tx stages changes to the tree below an object and publishes them
+// together. Objects created by a transaction are detached until it is
+// committed, so interfaces can be added to them without clients ever
+// seeing a half built object, and the signals their listeners receive
+// are only subscribed to once the commit succeeds. Method calls and
+// introspection made through the bus observe either none or all of a
+// transaction's changes.
+type Tx struct {
+	root *Object
+	ops  []txOp
+	done bool
+}
+
+type txOp struct {
+	path   []string
+	object *Object // nil for removals
+	mode   CreateMode
+}
+
+// Begin starts a transaction on the tree below o.
+func (o *Object) Begin() *Tx {
+	return &Tx{root: o}
+}
+
+// CreateObject stages the creation of an object at path relative to
+// the transaction's root. The returned object is attached to the tree
+// when the transaction commits; mode is applied at that point.
+func (tx *Tx) CreateObject(
+	path dbus.ObjectPath,
+	val interface{},
+	mode CreateMode,
+) (*Object, error) {
+	return tx.CreateObjectMap(path, val,
+		func(in string) string {
+			return in
+		}, mode)
+}
+
+// CreateObjectFromTable is like CreateObject but implements the object
+// with the functions in table, like Object.CreateObjectFromTable.
+func (tx *Tx) CreateObjectFromTable(
+	path dbus.ObjectPath,
+	table map[string]interface{},
+	mode CreateMode,
+) (*Object, error) {
+	return tx.stage(path, reflect.NewObjectFromTable(table), mode)
+}
+
+// CreateObjectMap is like CreateObject but maps the method names of
+// val with mapfn, like Object.CreateObjectMap.
+func (tx *Tx) CreateObjectMap(
+	path dbus.ObjectPath,
+	val interface{},
+	mapfn func(string) string,
+	mode CreateMode,
+) (*Object, error) {
+	return tx.stage(path, reflect.NewObjectMapNames(val, mapfn), mode)
+}
+
+func (tx *Tx) stage(
+	path dbus.ObjectPath,
+	impl *reflect.Object,
+	mode CreateMode,
+) (*Object, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if err := validateChildPath(path); err != nil {
+		return nil, err
+	}
+	elems := pathToStringSlice(path)
+	// staged objects keep a tree of their own and no bus until the
+	// commit so that building them neither generates events nor
+	// adds match rules
+	obj := newObjectFromImpl(elems[len(elems)-1], impl, nil, nil)
+	tx.ops = append(tx.ops, txOp{path: elems, object: obj, mode: mode})
+	return obj, nil
+}
+
+// RemoveObject stages the removal of the object at path relative to
+// the transaction's root.
+func (tx *Tx) RemoveObject(path dbus.ObjectPath) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if string(path) == "/" {
+		return &PathError{path, "the root object cannot be removed"}
+	}
+	if err := ValidatePath(path); err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, txOp{path: pathToStringSlice(path)})
+	return nil
+}
+
+// Rollback discards the transaction.
+func (tx *Tx) Rollback() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+}
+
+// Commit applies the staged changes in order. If any of them fails,
+// for instance with ErrObjectExists or ErrObjectNotFound, nothing is
+// applied and the error is returned. On success Commit returns the
+// consolidated interface changes, one entry per affected path. On a
+// BusManager's tree the same changes are announced from the
+// transaction's root with the InterfacesRemoved and InterfacesAdded
+// signals of org.freedesktop.DBus.ObjectManager.
+func (tx *Tx) Commit() ([]Change, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	tx.done = true
+	from, changes, err := tx.apply()
+	if err != nil {
+		return nil, err
+	}
+	if bus := tx.root.getBus(); bus != nil {
+		bus.emitChanges(from, changes)
+	}
+	return changes, nil
+}
+
+// apply checks and publishes the staged changes. It returns the path
+// of the transaction's root along with the changes.
+func (tx *Tx) apply() (dbus.ObjectPath, []Change, error) {
+	tree := tx.root.lockTree()
+	defer tree.Unlock()
+	v := newTxView()
+	for _, op := range tx.ops {
+		var err error
+		if op.object != nil {
+			err = v.create(tx.root, op)
+		} else {
+			err = v.remove(tx.root, op.path)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	// only now that every operation has been accepted do the staged
+	// objects join the tree and the bus; the match rules and owner
+	// watches they need are added once the tree is unlocked
+	bus := tx.root.getBus()
+	for _, op := range tx.ops {
+		if op.object == nil {
+			continue
+		}
+		for _, node := range op.object.subtree() {
+			node.rebindListeners(nil, bus)
+			node.rebindOwner(nil, bus)
+			node.bind(bus, tree)
+		}
+	}
+	v.publish()
+	return tx.root.Path(), v.changes(), nil
+}
+
+// emitChanges sends the ObjectManager signals for changes from the
+// object at path.
+func (mgr *BusManager) emitChanges(path dbus.ObjectPath, changes []Change) {
+	emit := func(name string, values ...interface{}) {
+		if err := mgr.conn.Emit(path, name, values...); err != nil {
+			mgr.state.getLogger().Warn("objtree: cannot emit signal",
+				"path", path, "signal", name, "error", err)
+		}
+	}
+	for _, change := range changes {
+		if len(change.Removed) > 0 {
+			emit(fdtInterfacesRemoved, change.Path, change.Removed)
+		}
+		if len(change.Added) > 0 {
+			added := make(map[string]map[string]dbus.Variant)
+			for _, name := range change.Added {
+				added[name] = make(map[string]dbus.Variant)
+			}
+			emit(fdtInterfacesAdded, change.Path, added)
+		}
+	}
+}
+
+// txView tracks the state of the tree as the operations of a
+// transaction are checked, without modifying it. Once every operation
+// has been accepted publish applies the result.
+type txView struct {
+	objects  map[*Object]*hamt.Map
+	real     map[*Object]bool
+	parents  map[*Object]*Object
+	fallback map[*Object]*Fallback
+	effects  []func()
+
+	paths  []dbus.ObjectPath
+	before map[dbus.ObjectPath][]string
+	after  map[dbus.ObjectPath]*Object
+}
+
+func newTxView() *txView {
+	return &txView{
+		objects:  make(map[*Object]*hamt.Map),
+		real:     make(map[*Object]bool),
+		parents:  make(map[*Object]*Object),
+		fallback: make(map[*Object]*Fallback),
+		before:   make(map[dbus.ObjectPath][]string),
+		after:    make(map[dbus.ObjectPath]*Object),
+	}
+}
+
+func (v *txView) children(node *Object) *hamt.Map {
+	if objects, ok := v.objects[node]; ok {
+		return objects
+	}
+	return node.getObjects()
+}
+
+func (v *txView) setChildren(node *Object, objects *hamt.Map) {
+	v.objects[node] = objects
+}
+
+func (v *txView) child(node *Object, name string) (*Object, bool) {
+	return lookupChild(v.children(node), name)
+}
+
+func (v *txView) isReal(node *Object) bool {
+	if real, ok := v.real[node]; ok {
+		return real
+	}
+	return node.hasActions()
+}
+
+func (v *txView) getFallback(node *Object) *Fallback {
+	if f, ok := v.fallback[node]; ok {
+		return f
+	}
+	return node.getFallback()
+}
+
+func (v *txView) parent(node *Object) *Object {
+	if parent, ok := v.parents[node]; ok {
+		return parent
+	}
+	return node.getParent()
+}
+
+// track records the interfaces at path the first time the transaction
+// touches it.
+func (v *txView) track(path dbus.ObjectPath, node *Object) {
+	if _, ok := v.before[path]; ok {
+		return
+	}
+	var names []string
+	if node != nil && v.isReal(node) {
+		names = exportedInterfaces(node)
+	}
+	v.before[path] = names
+	v.paths = append(v.paths, path)
+}
+
+func (v *txView) create(root *Object, op txOp) error {
+	node := root
+	for _, name := range op.path[:len(op.path)-1] {
+		child, ok := v.child(node, name)
+		if !ok {
+			//placeholder object for introspection; node may be
+			//an object staged by this transaction, which is not
+			//bound to root's tree and bus yet
+			child = newObjectFromImpl(name, nil, node, root.getBus())
+			child.bind(root.getBus(), root.getTree())
+			v.setChildren(node, v.children(node).Set(name, child))
+		}
+		node = child
+	}
+	name := op.path[len(op.path)-1]
+	obj := op.object
+	existing, ok := v.child(node, name)
+	path := childPath(root, op.path)
+	v.track(path, existing)
+	if ok && v.isReal(existing) && op.mode != CreateReplace {
+		return ErrObjectExists
+	}
+	v.parents[obj] = node
+	if ok {
+		//there may be child objects of the object that is being
+		//replaced; keep them
+		v.setChildren(obj, v.children(existing))
+		v.effects = append(v.effects, func() {
+			eachChild(v.children(obj), func(_ string, child *Object) {
+				child.setParent(obj)
+			})
+			obj.inheritInterceptors(existing)
+		})
+		if f := v.getFallback(existing); f != nil {
+			v.fallback[obj] = f
+			v.effects = append(v.effects, func() {
+				obj.fallback.Store(f)
+				f.setNode(obj)
+			})
+		}
+		v.effects = append(v.effects, existing.retire)
+	}
+	v.effects = append(v.effects, func() {
+		obj.setParent(node)
+		obj.emitAdded()
+	})
+	v.setChildren(node, v.children(node).Set(name, obj))
+	v.after[path] = obj
+	return nil
+}
+
+func (v *txView) remove(root *Object, elems []string) error {
+	node := root
+	for _, name := range elems[:len(elems)-1] {
+		child, ok := v.child(node, name)
+		if !ok {
+			return ErrObjectNotFound
+		}
+		node = child
+	}
+	name := elems[len(elems)-1]
+	obj, ok := v.child(node, name)
+	switch {
+	case !ok:
+		return ErrObjectNotFound
+	case !v.isReal(obj) && v.getFallback(obj) == nil:
+		return ErrPlaceholder
+	}
+	path := childPath(root, elems)
+	v.track(path, obj)
+	v.real[obj] = false
+	v.fallback[obj] = nil
+	v.effects = append(v.effects, obj.demote)
+	// if there are children keep obj as their placeholder
+	if v.children(obj).Len() == 0 {
+		v.setChildren(node, v.children(node).Delete(name))
+	}
+	v.after[path] = nil
+	v.prune(node)
+	return nil
+}
+
+// prune removes placeholders left without children, like pruneChild.
+func (v *txView) prune(node *Object) {
+	for {
+		parent := v.parent(node)
+		if parent == nil || v.isReal(node) || v.getFallback(node) != nil ||
+			v.children(node).Len() > 0 {
+			return
+		}
+		name := node.getName()
+		if child, _ := v.child(parent, name); child != node {
+			return
+		}
+		v.setChildren(parent, v.children(parent).Delete(name))
+		node = parent
+	}
+}
+
+func (v *txView) publish() {
+	for _, effect := range v.effects {
+		effect()
+	}
+	for node, objects := range v.objects {
+		node.objects.Update(func(interface{}) interface{} {
+			return objects
+		})
+	}
+}
+
+func (v *txView) changes() []Change {
+	var out []Change
+	for _, path := range v.paths {
+		var after []string
+		if obj := v.after[path]; obj != nil {
+			after = exportedInterfaces(obj)
+		}
+		added, removed := diffNames(v.before[path], after)
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		out = append(out, Change{
+			Path:    path,
+			Added:   added,
+			Removed: removed,
+		})
+	}
+	return out
+}
+
+func childPath(root *Object, elems []string) dbus.ObjectPath {
+	path := string(root.Path())
+	if path == "/" {
+		path = ""
+	}
+	for _, elem := range elems {
+		path += "/" + elem
+	}
+	return dbus.ObjectPath(path)
+}
+
+func diffNames(before, after []string) (added, removed []string) {
+	seen := make(map[string]bool)
+	for _, name := range before {
+		seen[name] = true
+	}
+	for _, name := range after {
+		if !seen[name] {
+			added = append(added, name)
+		}
+		delete(seen, name)
+	}
+	for _, name := range before {
+		if seen[name] {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,57 @@
+package objtree_test
+
+import (
+	"github.com/godbus/dbus"
+	"github.com/jsouthworth/objtree"
+	"github.com/jsouthworth/objtree/objtreetest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const fdtObjectManager = "org.freedesktop.DBus.ObjectManager"
+
+func TestTxSignals(t *testing.T) {
+	srv := objtreetest.NewServer(t, "com.example.Users",
+		func(mgr *objtree.BusManager) error {
+			return mgr.NewObject("/users/bob", &user{name: "bob"}).
+				Implements("com.example.User", (*interface {
+					Name() string
+				})(nil))
+		})
+	w := srv.WatchSignals("type='signal',interface='" +
+		fdtObjectManager + "'")
+	tx := srv.Manager().Begin()
+	carol, _ := tx.CreateObject("/users/carol", &user{name: "carol"},
+		objtree.CreateExclusive)
+	carol.ImplementsTable("com.example.User", map[string]interface{}{
+		"Name": func() string { return "carol" },
+	})
+	tx.RemoveObject("/users/bob")
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	sig := w.Expect(fdtObjectManager+".InterfacesAdded", time.Second)
+	var path dbus.ObjectPath
+	var added map[string]map[string]dbus.Variant
+	if err := dbus.Store(sig.Body, &path, &added); err != nil {
+		t.Fatal(err)
+	}
+	if sig.Path != "/" || path != "/users/carol" {
+		t.Fatal("unexpected signal:", sig)
+	}
+	if _, ok := added["com.example.User"]; !ok || len(added) != 1 {
+		t.Fatal("unexpected interfaces:", added)
+	}
+	sig = w.Expect(fdtObjectManager+".InterfacesRemoved", time.Second)
+	var removed []string
+	if err := dbus.Store(sig.Body, &path, &removed); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/users/bob" ||
+		!reflect.DeepEqual(removed, []string{"com.example.User"}) {
+		t.Fatal("unexpected signal:", sig)
+	}
+	w.ExpectNone(fdtObjectManager+".InterfacesAdded", 100*time.Millisecond)
+}
@@ -0,0 +1,224 @@
+package objtree
+
+import (
+	"github.com/godbus/dbus"
+	"reflect"
+	"testing"
+)
+
+func TestTxCommit(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	tx := root.Begin()
+	obj, err := tx.CreateObject("/foo/bar", &testObj{}, CreateExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Implements("foo", (*testIface)(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := root.LookupObject("foo"); ok {
+		t.Fatal("staged objects should not be visible before commit")
+	}
+	changes, err := tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, ok := lookupPath(root, "/foo/bar")
+	if !ok || found != obj {
+		t.Fatal("expected staged object after commit")
+	}
+	if obj.Path() != "/foo/bar" {
+		t.Fatal("unexpected path:", obj.Path())
+	}
+	expected := []Change{{Path: "/foo/bar", Added: []string{"foo"}}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected: %+v got: %+v", expected, changes)
+	}
+	if _, err := tx.Commit(); err != ErrTxDone {
+		t.Fatal("expected ErrTxDone got:", err)
+	}
+}
+
+func TestTxConflict(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	existing := root.NewObject("/foo", &testObj{})
+	tx := root.Begin()
+	tx.CreateObject("/bar", &testObj{}, CreateExclusive)
+	tx.CreateObject("/foo", &testObj{}, CreateExclusive)
+	if _, err := tx.Commit(); err != ErrObjectExists {
+		t.Fatal("expected ErrObjectExists got:", err)
+	}
+	if _, ok := root.LookupObject("bar"); ok {
+		t.Fatal("failed transaction should not apply any change")
+	}
+	if found, _ := root.LookupObject("foo"); found != existing {
+		t.Fatal("failed transaction should not replace objects")
+	}
+}
+
+func TestTxRemove(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.NewObject("/foo/bar", &testObj{}).
+		Implements("foo", (*testIface)(nil))
+	root.NewObject("/baz", &testObj{})
+	tx := root.Begin()
+	tx.RemoveObject("/foo/bar")
+	obj, _ := tx.CreateObject("/baz", &testObj{}, CreateReplace)
+	obj.Implements("foo", (*testIface)(nil))
+	changes, err := tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := root.LookupObject("foo"); ok {
+		t.Fatal("placeholder should have been pruned")
+	}
+	expected := []Change{
+		{Path: "/foo/bar", Removed: []string{"foo"}},
+		{Path: "/baz", Added: []string{"foo"}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected: %+v got: %+v", expected, changes)
+	}
+}
+
+func TestTxRemoveErrors(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	root.NewObject("/foo/bar", &testObj{})
+	for path, expected := range map[dbus.ObjectPath]error{
+		"/foo":     ErrPlaceholder,
+		"/foo/baz": ErrObjectNotFound,
+		"/qux/baz": ErrObjectNotFound,
+	} {
+		tx := root.Begin()
+		tx.RemoveObject(path)
+		if _, err := tx.Commit(); err != expected {
+			t.Fatalf("%s: expected %v got: %v", path, expected, err)
+		}
+	}
+	tx := root.Begin()
+	tx.CreateObject("/qux", &testObj{}, CreateExclusive)
+	tx.RemoveObject("/qux")
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := root.LookupObject("qux"); ok {
+		t.Fatal("object should have been removed")
+	}
+}
+
+func TestTxKeepsChildren(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	bar := root.NewObject("/foo/bar", &testObj{})
+	tx := root.Begin()
+	foo, _ := tx.CreateObject("/foo", &testObj{}, CreateExclusive)
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := root.LookupObject("foo"); found != foo {
+		t.Fatal("expected staged object")
+	}
+	if found, _ := lookupPath(root, "/foo/bar"); found != bar {
+		t.Fatal("children should be kept")
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	root := newObjectFromImpl("", nil, nil, nil)
+	tx := root.Begin()
+	tx.CreateObject("/foo", &testObj{}, CreateExclusive)
+	tx.Rollback()
+	if _, err := tx.Commit(); err != ErrTxDone {
+		t.Fatal("expected ErrTxDone got:", err)
+	}
+	if _, ok := root.LookupObject("foo"); ok {
+		t.Fatal("rolled back transaction should not apply")
+	}
+}
+
+func TestBusManagerTx(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	tx := mgr.Begin()
+	obj, _ := tx.CreateObject("/foo/bar", &testObj{}, CreateExclusive)
+	obj.Implements("foo.bar", (*testIface)(nil))
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	client := newLoopbackClient(t, bus)
+	var out string
+	err := client.Object("com.github.jsouthworth.objtree.Test",
+		"/foo/bar").Call("foo.bar.CallMe", 0).Store(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "hello, world" {
+		t.Fatal("unexpected output:", out)
+	}
+}
+
+func TestBusManagerTxMatches(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	mgr.NewObject("/foo", &testObj{})
+	table := map[string]interface{}{
+		"Changed": func(string) {},
+	}
+	rule := signalKey{"com.example.Test", "Changed"}.rule()
+	stage := func(mode CreateMode) *Tx {
+		tx := mgr.Begin()
+		obj, _ := tx.CreateObjectFromTable("/foo", table, mode)
+		if err := obj.ReceivesTable("com.example.Test", table); err != nil {
+			t.Fatal(err)
+		}
+		if hasMatch(mgr, rule) {
+			t.Fatal("staged listeners should not add match rules")
+		}
+		return tx
+	}
+	if _, err := stage(CreateExclusive).Commit(); err != ErrObjectExists {
+		t.Fatal("expected ErrObjectExists got:", err)
+	}
+	if hasMatch(mgr, rule) {
+		t.Fatal("a failed commit should not add match rules")
+	}
+	if _, err := stage(CreateReplace).Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasMatch(mgr, rule) {
+		t.Fatal("expected the rule to be added on commit")
+	}
+}
+
+func TestBusManagerTxNested(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	tx := mgr.Begin()
+	a, _ := tx.CreateObject("/a", &testObj{}, CreateExclusive)
+	y, _ := tx.CreateObject("/a/x/y", &testObj{}, CreateExclusive)
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	x, ok := lookupPath(mgr.Object, "/a/x")
+	if !ok || x.Parent() != a || y.Parent() != x {
+		t.Fatal("expected the placeholder between the staged objects")
+	}
+	for _, obj := range []*Object{a, x, y} {
+		if obj.Manager() != mgr || obj.getTree() != mgr.getTree() {
+			t.Fatal("not bound to the manager:", obj.Path())
+		}
+	}
+	z := x.NewObjectFromTable("z", map[string]interface{}{
+		"Changed": func(string) {},
+	})
+	if z.getTree() != mgr.getTree() {
+		t.Fatal("objects created below the placeholder should join the tree")
+	}
+	if err := z.ReceivesTable("com.example.Test", map[string]interface{}{
+		"Changed": func(string) {},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !hasMatch(mgr, signalKey{"com.example.Test", "Changed"}.rule()) {
+		t.Fatal("expected the rule to be added")
+	}
+}
@@ -0,0 +1,104 @@
+package objtree
+
+import (
+	"errors"
+	"github.com/godbus/dbus"
+	"path"
+	"sort"
+	"strings"
+)
+
+// SkipChildren can be returned by a WalkFunc to skip the objects below
+// the current one.
+var SkipChildren = errors.New("skip children")
+
+// WalkFunc is called by Walk for each object with the object's full
+// path. If it returns an error other than SkipChildren the walk stops
+// and Walk returns that error.
+type WalkFunc func(path dbus.ObjectPath, obj *Object) error
+
+// Walk calls fn for o and every object below it in lexical order.
+// Placeholders are descended into but not passed to fn, and objects
+// served by a Fallback are not visited. Each object's children are
+// read from a single snapshot, so concurrent changes do not disturb
+// the walk but may or may not be observed by it.
+func (o *Object) Walk(fn WalkFunc) error {
+	err := o.walk(o.Path(), fn)
+	if err == SkipChildren {
+		return nil
+	}
+	return err
+}
+
+func (o *Object) walk(p dbus.ObjectPath, fn WalkFunc) error {
+	if o.hasActions() {
+		switch err := fn(p, o); err {
+		case nil:
+		case SkipChildren:
+			return nil
+		default:
+			return err
+		}
+	}
+	objects := o.getObjects()
+	names := make([]string, 0, objects.Len())
+	eachChild(objects, func(name string, _ *Object) {
+		names = append(names, name)
+	})
+	sort.Strings(names)
+	prefix := string(p)
+	if prefix == "/" {
+		prefix = ""
+	}
+	for _, name := range names {
+		child, _ := lookupChild(objects, name)
+		err := child.walk(dbus.ObjectPath(prefix+"/"+name), fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *Object) collect(match func(dbus.ObjectPath, *Object) bool) []dbus.ObjectPath {
+	var out []dbus.ObjectPath
+	o.Walk(func(p dbus.ObjectPath, obj *Object) error {
+		if match(p, obj) {
+			out = append(out, p)
+		}
+		return nil
+	})
+	return out
+}
+
+// Find returns the paths of the objects at or below o that implement
+// the named interface.
+func (o *Object) Find(iface string) []dbus.ObjectPath {
+	return o.collect(func(_ dbus.ObjectPath, obj *Object) bool {
+		_, ok := obj.LookupInterface(iface)
+		return ok
+	})
+}
+
+// Glob returns the paths of the objects at or below o whose full path
+// matches pattern. The pattern syntax is that of path.Match, so '*'
+// matches within a single path element.
+func (o *Object) Glob(pattern string) ([]dbus.ObjectPath, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return o.collect(func(p dbus.ObjectPath, _ *Object) bool {
+		ok, _ := path.Match(pattern, string(p))
+		return ok
+	}), nil
+}
+
+// WithPrefix returns the paths of the objects at or below o that are
+// equal to prefix or are descendants of it, following the semantics
+// of the path_namespace match rule key.
+func (o *Object) WithPrefix(prefix dbus.ObjectPath) []dbus.ObjectPath {
+	ns := strings.TrimSuffix(string(prefix), "/")
+	return o.collect(func(p dbus.ObjectPath, _ *Object) bool {
+		return string(p) == ns || strings.HasPrefix(string(p), ns+"/")
+	})
+}
@@ -0,0 +1,130 @@
+package objtree
+
+import (
+	"errors"
+	"github.com/godbus/dbus"
+	"reflect"
+	"testing"
+)
+
+func newWalkTree() *Object {
+	root := newObjectFromImpl("", nil, nil, nil)
+	for _, path := range []dbus.ObjectPath{
+		"/foo", "/foo/bar", "/foo/baz", "/qux/quux", "/foobar",
+	} {
+		obj := root.NewObject(path, &testObj{})
+		if path == "/foo/bar" || path == "/qux/quux" {
+			obj.Implements("foo", (*testIface)(nil))
+		}
+	}
+	return root
+}
+
+func TestWalk(t *testing.T) {
+	root := newWalkTree()
+	var paths []dbus.ObjectPath
+	err := root.Walk(func(path dbus.ObjectPath, obj *Object) error {
+		if obj.Path() != path {
+			t.Fatal("unexpected path:", path, obj.Path())
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []dbus.ObjectPath{
+		"/foo", "/foo/bar", "/foo/baz", "/foobar", "/qux/quux",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatal("expected:", expected, "got:", paths)
+	}
+}
+
+func TestWalkSubtree(t *testing.T) {
+	root := newWalkTree()
+	foo, _ := root.LookupObject("foo")
+	expected := []dbus.ObjectPath{"/foo", "/foo/bar", "/foo/baz"}
+	if got := foo.collect(func(dbus.ObjectPath, *Object) bool {
+		return true
+	}); !reflect.DeepEqual(got, expected) {
+		t.Fatal("expected:", expected, "got:", got)
+	}
+}
+
+func TestWalkSkipChildren(t *testing.T) {
+	root := newWalkTree()
+	var paths []dbus.ObjectPath
+	root.Walk(func(path dbus.ObjectPath, obj *Object) error {
+		paths = append(paths, path)
+		if path == "/foo" {
+			return SkipChildren
+		}
+		return nil
+	})
+	expected := []dbus.ObjectPath{"/foo", "/foobar", "/qux/quux"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatal("expected:", expected, "got:", paths)
+	}
+}
+
+func TestWalkError(t *testing.T) {
+	root := newWalkTree()
+	stop := errors.New("stop")
+	calls := 0
+	err := root.Walk(func(path dbus.ObjectPath, obj *Object) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Fatal("expected walk to stop, got:", err, calls)
+	}
+}
+
+func TestFind(t *testing.T) {
+	root := newWalkTree()
+	expected := []dbus.ObjectPath{"/foo/bar", "/qux/quux"}
+	if got := root.Find("foo"); !reflect.DeepEqual(got, expected) {
+		t.Fatal("expected:", expected, "got:", got)
+	}
+	if got := root.Find("missing"); len(got) != 0 {
+		t.Fatal("unexpected objects:", got)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	root := newWalkTree()
+	got, err := root.Glob("/foo/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []dbus.ObjectPath{"/foo/bar", "/foo/baz"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatal("expected:", expected, "got:", got)
+	}
+	if _, err := root.Glob("/foo/["); err == nil {
+		t.Fatal("expected bad pattern error")
+	}
+}
+
+func TestWithPrefix(t *testing.T) {
+	root := newWalkTree()
+	expected := []dbus.ObjectPath{"/foo", "/foo/bar", "/foo/baz"}
+	if got := root.WithPrefix("/foo"); !reflect.DeepEqual(got, expected) {
+		t.Fatal("expected:", expected, "got:", got)
+	}
+	if got := root.WithPrefix("/"); len(got) != 5 {
+		t.Fatal("expected all objects got:", got)
+	}
+}
+
+func TestBusManagerFind(t *testing.T) {
+	bus, mgr := newLoopbackBusManager(t)
+	defer bus.Close()
+	mgr.NewObject("/foo/bar", &testObj{}).
+		Implements("foo", (*testIface)(nil))
+	expected := []dbus.ObjectPath{"/foo/bar"}
+	if got := mgr.Find("foo"); !reflect.DeepEqual(got, expected) {
+		t.Fatal("expected:", expected, "got:", got)
+	}
+}